@@ -0,0 +1,53 @@
+// Incremental scans of the projects that changed, using Cloud Asset Inventory
+//
+// Cloud Asset Inventory knows when compute resources were last updated, so a
+// search tells which projects had address or instance changes since a given
+// time. Only those are fetched again; the others are taken from the state
+// file of a previous run. Deleted resources don't show up in the search, so a
+// project whose only change is a deletion keeps its cached results.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudasset/v1"
+	"google.golang.org/api/option"
+)
+
+// Asset types whose changes make a project worth fetching again
+var changeAssetTypes = []string{
+	"compute.googleapis.com/Address",
+	"compute.googleapis.com/GlobalAddress",
+	"compute.googleapis.com/Instance",
+	"compute.googleapis.com/ForwardingRule",
+	"compute.googleapis.com/GlobalForwardingRule",
+}
+
+// Get the IDs of the projects under scope (e.g. organizations/123 or folders/456)
+// with address, instance or forwarding rule changes after since
+func getChangedProjects(ctx context.Context, client *http.Client, scope string, since time.Time) (map[string]bool, error) {
+	assetService, err := cloudasset.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	err = assetService.V1.SearchAllResources(scope).
+		AssetTypes(changeAssetTypes...).
+		Query(fmt.Sprintf("updateTime>%d", since.Unix())).
+		Pages(ctx, func(page *cloudasset.SearchAllResourcesResponse) error {
+			for _, result := range page.Results {
+				if project := gcpips.ResourceProject(result.Name); project != "" {
+					changed[project] = true
+				}
+			}
+			return nil
+		})
+
+	return changed, err
+}
@@ -0,0 +1,48 @@
+// Comparison against a previous run, to only report newly appeared addresses
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// Key identifying an address across runs
+func baselineKey(addressInfo *AddressInfo) string {
+	return addressInfo.Project + "/" + addressInfo.IP
+}
+
+// Load the addresses of a previous JSON report
+// paths is a comma-separated list of files, each holding a JSON array of addresses
+// (as written by -format json or sent to -post-url)
+func loadBaseline(paths string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, path := range strings.Split(paths, ",") {
+		data, err := ioutil.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		var addressInfoList []*AddressInfo
+		if err := json.Unmarshal(data, &addressInfoList); err != nil {
+			return nil, err
+		}
+		for _, addressInfo := range addressInfoList {
+			known[baselineKey(addressInfo)] = true
+		}
+	}
+	return known, nil
+}
+
+// Drop the addresses that are in the baseline, keeping only the new ones
+func newOnly(addressesBySubnet map[string][]*AddressInfo, known map[string]bool) map[string][]*AddressInfo {
+	fresh := make(map[string][]*AddressInfo)
+	for subnet, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if !known[baselineKey(addressInfo)] {
+				fresh[subnet] = append(fresh[subnet], addressInfo)
+			}
+		}
+	}
+	return fresh
+}
@@ -0,0 +1,81 @@
+// Announcement status of external IPs from bring-your-own-IP (BYOIP) ranges
+//
+// BYOIP addresses are allocated from public delegated prefixes, which only
+// route traffic once announced. An address can be reserved in a prefix that
+// isn't announced (yet), so it is not actually reachable.
+
+package main
+
+import (
+	"net"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Statuses of a public delegated prefix that is live on the internet
+var announcedStatuses = map[string]bool{
+	"ANNOUNCED":             true,
+	"ANNOUNCED_TO_INTERNET": true,
+}
+
+// publicDelegatedPrefix is a BYOIP range and whether it is announced
+type publicDelegatedPrefix struct {
+	ipNet     *net.IPNet
+	announced bool
+}
+
+// Get the public delegated prefixes of the given projects
+func getPublicDelegatedPrefixes(ctx context.Context, projects []string, service *compute.Service, opts *options) ([]*publicDelegatedPrefix, []string) {
+	var prefixes []*publicDelegatedPrefix
+	var errs []string
+
+	for _, project := range projects {
+		var list []*compute.PublicDelegatedPrefix
+		err := gcpips.WithRetry(ctx, &opts.Options, "getting public delegated prefixes of "+project, func() (err error) {
+			list, err = gcpips.AllPublicDelegatedPrefixPages(ctx, service.PublicDelegatedPrefixes.AggregatedList(project))
+			return err
+		})
+		if err != nil {
+			logger.Error("Error getting public delegated prefixes", "project", project, "error", err)
+			errs = append(errs, project+": error getting public delegated prefixes: "+err.Error())
+			continue
+		}
+
+		for _, prefix := range list {
+			_, ipNet, err := net.ParseCIDR(prefix.IpCidrRange)
+			if err != nil {
+				continue
+			}
+			prefixes = append(prefixes, &publicDelegatedPrefix{
+				ipNet:     ipNet,
+				announced: announcedStatuses[prefix.Status],
+			})
+		}
+	}
+
+	return prefixes, errs
+}
+
+// Set the Announced field of the external addresses that belong to a public delegated prefix
+// Addresses outside of all prefixes (i.e. Google-owned IPs) are left blank
+func annotateAnnounced(addressesBySubnet map[string][]*AddressInfo, prefixes []*publicDelegatedPrefix) {
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Type != "EXTERNAL" {
+				continue
+			}
+			ip := parseAddr(addressInfo.IP)
+			for _, prefix := range prefixes {
+				if ip != nil && prefix.ipNet.Contains(ip) {
+					addressInfo.Announced = "no"
+					if prefix.announced {
+						addressInfo.Announced = "yes"
+					}
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Cross-check of external IPs against the records of a Cloud DNS managed zone
+//
+// External IPs without a forward record are easily overlooked, as they are
+// missing from what is usually the source of truth for public endpoints
+
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/option"
+)
+
+// Report of the external IPs without a DNS record
+const noDNSReportFile = "no-dns.md"
+
+// Get the set of IPs that A and AAAA records of a managed zone point to
+func getDNSRecordIPs(ctx context.Context, client *http.Client, project string, zone string) (map[string]bool, error) {
+	dnsService, err := dns.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]bool)
+	err = dnsService.ResourceRecordSets.List(project, zone).Pages(ctx, func(page *dns.ResourceRecordSetsListResponse) error {
+		for _, rrset := range page.Rrsets {
+			if rrset.Type != "A" && rrset.Type != "AAAA" {
+				continue
+			}
+			for _, rrdata := range rrset.Rrdatas {
+				if ip := net.ParseIP(rrdata); ip != nil {
+					ips[ip.String()] = true
+				}
+			}
+		}
+		return nil
+	})
+
+	return ips, err
+}
+
+// Write a report of the external IPs that no A/AAAA record in the managed zone points to
+// Nothing is written when they all have one, and the report of a previous run is removed
+// IPs are compared unmasked, and masked with mask in the report
+func checkDNS(ctx context.Context, client *http.Client, project string, zone string, addressesBySubnet map[string][]*AddressInfo, columns []column, sink outputSink, mask func(ip string) string) error {
+	recorded, err := getDNSRecordIPs(ctx, client, project, zone)
+	if err != nil {
+		return err
+	}
+
+	var missing []*AddressInfo
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Type != "EXTERNAL" {
+				continue
+			}
+			if ip := net.ParseIP(addressInfo.IP); ip != nil && !recorded[ip.String()] {
+				missing = append(missing, addressInfo)
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return removeReport(sink, noDNSReportFile)
+	}
+
+	logger.Warn("External IPs have no DNS record", "ips", len(missing), "report", noDNSReportFile)
+	return writeReport(sink, noDNSReportFile, "External IPs without a DNS record in "+zone, columns, missing, mask)
+}
@@ -0,0 +1,39 @@
+// Dry runs, which scan everything but don't write or send anything
+//
+// A dry run logs the files it would write and how many addresses each would
+// hold, to check a run before pointing it at a shared directory, or just to
+// count addresses without generating files.
+
+package main
+
+import (
+	"google.golang.org/api/compute/v1"
+)
+
+// Log the files writeAll would write, with the number of addresses of each, and their totals
+// Returns the number of files that would be written
+func dryRun(addressesBySubnet map[string][]*AddressInfo, subnets map[string]*compute.Subnetwork, renderer Renderer, out *output, opts *options) int {
+	groups := subnetGroups(addressesBySubnet, subnets, opts)
+
+	total := 0
+	for _, group := range groups {
+		total += len(group.Rows)
+		if out.single == "" {
+			name, err := opts.fileNames.name(group, renderer.Extension())
+			if err != nil {
+				logger.Error("Would fail to write", "subnet", group.Name, "error", err)
+				continue
+			}
+			logger.Progress("Would write", "file", out.sink.Path(name), "addresses", len(group.Rows))
+		} else {
+			logger.Progress("Would write", "subnet", group.Name, "file", out.sink.Path(out.single), "addresses", len(group.Rows))
+		}
+	}
+
+	files := len(groups)
+	if out.single != "" {
+		files = 1
+	}
+	logger.Info("Dry run, nothing written", "subnets", len(groups), "ips", total, "files", files)
+	return files
+}
@@ -0,0 +1,31 @@
+// Errors that don't stop the run, such as a project that couldn't be read
+//
+// They are logged as they happen and summarized in a file at the end, so that
+// a partially failed run still produces output for everything that worked
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// File the error summary is written to
+const errorSummaryFile = "errors.md"
+
+// Write the errors of the run to a Markdown file in sink, one bullet per error
+func writeErrorSummary(sink outputSink, filename string, errs []string) error {
+	return writeFile(context.Background(), sink, filename, func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "# Errors\n\n"); err != nil {
+			return err
+		}
+		for _, e := range errs {
+			if _, err := fmt.Fprintf(w, "- %s\n", e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
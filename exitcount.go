@@ -0,0 +1,53 @@
+// Exit status that carries a count, for schedulers that only look at $?
+//
+// With -exit-count, a successful run exits with the number of matching
+// addresses, capped at maxExitCount, and a failed run with exitCountFailure
+
+package main
+
+import (
+	"fmt"
+)
+
+// Highest count the exit status can carry, larger counts are reported as this
+const maxExitCount = 254
+
+// Exit status of a failed run with -exit-count
+const exitCountFailure = 255
+
+// What -exit-count counts
+var exitCounters = map[string]func(*AddressInfo) bool{
+	// reserved external addresses that nothing uses
+	"orphaned-external": func(a *AddressInfo) bool {
+		return a.Type == "EXTERNAL" && a.Status == "RESERVED"
+	},
+	// all rows of the output
+	"rows": func(a *AddressInfo) bool {
+		return true
+	},
+}
+
+// Check an -exit-count value
+func validateExitCount(mode string) error {
+	if _, ok := exitCounters[mode]; !ok {
+		return fmt.Errorf("unknown -exit-count %q (expected orphaned-external or rows)", mode)
+	}
+	return nil
+}
+
+// Exit status for the addresses counted by mode, capped at maxExitCount
+func exitCount(mode string, addressesBySubnet map[string][]*AddressInfo) int {
+	matches := exitCounters[mode]
+	count := 0
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if matches(addressInfo) {
+				count++
+			}
+		}
+	}
+	if count > maxExitCount {
+		return maxExitCount
+	}
+	return count
+}
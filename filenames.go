@@ -0,0 +1,97 @@
+// Names of the per-subnet files, from the -filename-template
+//
+// The template is a text/template, e.g. "{{.Date}}_{{.Network}}_{{.Subnet}}.{{.Ext}}".
+// Rendered names are sanitized, so that a value can't point outside the
+// output directory or use characters that common filesystems reject.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default -filename-template: the subnet, with the extension of the format
+const defaultFileNameTemplate = "{{.Subnet}}.{{.Ext}}"
+
+// Replaces path separators and the characters Windows (the strictest of the common filesystems) rejects
+var fileNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// fileNameData holds the fields of a -filename-template
+type fileNameData struct {
+	Subnet  string // name of the group: the subnet, or the project or network with -group-by
+	Network string // network of all IPs of the group, "" when they differ
+	Project string // project of all IPs of the group, "" when they differ
+	Date    string // date of the run (UTC), e.g. 2024-06-01
+	Ext     string // extension of the output format, e.g. md
+}
+
+// fileNames names the files of a run
+type fileNames struct {
+	template *template.Template
+	date     string
+}
+
+// Parse a -filename-template, for a run started at start
+// The template is tried out, so that unknown fields are reported right away
+func parseFileNames(text string, start time.Time) (*fileNames, error) {
+	t, err := template.New("filename").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	names := &fileNames{template: t, date: start.UTC().Format("2006-01-02")}
+	if _, err := names.name(&subnetGroup{Name: "subnet"}, "md"); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Name of the file of group, for a format with the given extension
+func (f *fileNames) name(group *subnetGroup, extension string) (string, error) {
+	data := &fileNameData{
+		Subnet:  group.Name,
+		Network: commonValue(group.Rows, func(a *AddressInfo) string { return a.Network }),
+		Project: commonValue(group.Rows, func(a *AddressInfo) string { return a.Project }),
+		Date:    f.date,
+		Ext:     extension,
+	}
+	var buf bytes.Buffer
+	if err := f.template.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	name := sanitizeFileName(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("-filename-template gives an empty name for %s", group.Name)
+	}
+	return name, nil
+}
+
+// Make name safe to use as a file name in the output directory
+// Path separators, characters illegal on common filesystems and control characters
+// are replaced by "_", and leading and trailing dots and spaces are removed
+func sanitizeFileName(name string) string {
+	name = fileNameReplacer.Replace(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, ". ")
+}
+
+// The value of all rows, "" when they don't all have the same one
+func commonValue(rows []*AddressInfo, value func(*AddressInfo) string) string {
+	common := ""
+	for i, addressInfo := range rows {
+		v := value(addressInfo)
+		if i > 0 && v != common {
+			return ""
+		}
+		common = v
+	}
+	return common
+}
@@ -0,0 +1,65 @@
+// The part of the Compute API a scan goes through
+//
+// The scan only needs a handful of list calls, so it takes them through the
+// ComputeClient interface rather than a *compute.Service. NewComputeClient
+// wraps the real service; a fake implementation returning canned lists lets
+// callers exercise the scan without GCP.
+
+package gcpips
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// ComputeClient lists the resources a scan looks at, with all pages merged
+// A pageSize of 0 leaves the page size to the API
+type ComputeClient interface {
+	AddressAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.AddressAggregatedList, error)
+	InstanceAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.InstanceAggregatedList, error)
+	ForwardingRuleAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.ForwardingRuleAggregatedList, error)
+	XpnResources(ctx context.Context, hostProject string) (*compute.ProjectsGetXpnResources, error)
+	Subnetworks(ctx context.Context, project string) ([]*compute.Subnetwork, error)
+}
+
+// serviceClient is the ComputeClient of a real Compute API service
+type serviceClient struct {
+	service *compute.Service
+}
+
+// NewComputeClient returns the ComputeClient that makes its calls with service
+func NewComputeClient(service *compute.Service) ComputeClient {
+	return &serviceClient{service: service}
+}
+
+func (c *serviceClient) AddressAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.AddressAggregatedList, error) {
+	call := c.service.Addresses.AggregatedList(project)
+	if pageSize > 0 {
+		call.MaxResults(pageSize)
+	}
+	return allAddressPages(ctx, call)
+}
+
+func (c *serviceClient) InstanceAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.InstanceAggregatedList, error) {
+	call := c.service.Instances.AggregatedList(project)
+	if pageSize > 0 {
+		call.MaxResults(pageSize)
+	}
+	return allInstancePages(ctx, call)
+}
+
+func (c *serviceClient) ForwardingRuleAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.ForwardingRuleAggregatedList, error) {
+	call := c.service.ForwardingRules.AggregatedList(project)
+	if pageSize > 0 {
+		call.MaxResults(pageSize)
+	}
+	return allForwardingRulePages(ctx, call)
+}
+
+func (c *serviceClient) XpnResources(ctx context.Context, hostProject string) (*compute.ProjectsGetXpnResources, error) {
+	return allXpnResourcePages(ctx, c.service.Projects.GetXpnResources(hostProject))
+}
+
+func (c *serviceClient) Subnetworks(ctx context.Context, project string) ([]*compute.Subnetwork, error) {
+	return allSubnetworkPages(ctx, c.service.Subnetworks.AggregatedList(project))
+}
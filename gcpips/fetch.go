@@ -0,0 +1,250 @@
+// Fetching of the service projects and their resources
+
+package gcpips
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// GetServiceProjects gets the list of service projects for a given host project
+func GetServiceProjects(ctx context.Context, hostProject string, client ComputeClient, opts *Options) (*compute.ProjectsGetXpnResources, error) {
+	logger := ProjectLogger(hostProject, opts)
+	logger.Progress("Looking for service projects")
+
+	var res *compute.ProjectsGetXpnResources
+	err := WithRetry(ctx, opts, "getting service projects of "+hostProject, func() (err error) {
+		res, err = client.XpnResources(ctx, hostProject)
+		return err
+	})
+
+	if err != nil {
+		logger.Error("Error getting service projects", "error", err)
+	}
+
+	return res, err
+}
+
+// MaxPageSizeLimit is the largest page size accepted by the AggregatedList calls
+const MaxPageSizeLimit = 500
+
+// GetSubnets gets the subnets of a project, keyed by SubnetKey
+// In a shared VPC, the subnets live in the host project
+func GetSubnets(ctx context.Context, project string, client ComputeClient, opts *Options) (map[string]*compute.Subnetwork, error) {
+	subnets := make(map[string]*compute.Subnetwork)
+
+	var subnetworks []*compute.Subnetwork
+	err := WithRetry(ctx, opts, "getting subnets of "+project, func() (err error) {
+		subnetworks, err = client.Subnetworks(ctx, project)
+		return err
+	})
+	if err != nil {
+		return subnets, err
+	}
+
+	for _, subnetwork := range subnetworks {
+		subnets[SubnetKey(subnetwork.SelfLink, GetName(subnetwork.Network), opts)] = subnetwork
+	}
+
+	return subnets, nil
+}
+
+// GetResources gets the AddressAggregatedList, InstanceAggregatedList and ForwardingRuleAggregatedList of a project
+// Lists that can't be fetched are left nil, and their errors recorded in Errors
+func GetResources(ctx context.Context, project string, client ComputeClient, opts *Options) *ProjectResources {
+	logger := ProjectLogger(project, opts)
+	logger.Progress("Looking for instances and IPs")
+
+	var errs []string
+
+	var addressAggregatedList *compute.AddressAggregatedList
+	err := WithRetry(ctx, opts, "getting reserved IPs for "+project, func() (err error) {
+		addressAggregatedList, err = client.AddressAggregatedList(ctx, project, opts.MaxPageSize)
+		return err
+	})
+
+	if err != nil {
+		logger.Error("Error getting reserved IPs", "error", err)
+		errs = append(errs, fmt.Sprintf("error getting reserved IPs: %s", err))
+	}
+
+	var instanceAggregatedList *compute.InstanceAggregatedList
+	err = WithRetry(ctx, opts, "getting instances for "+project, func() (err error) {
+		instanceAggregatedList, err = client.InstanceAggregatedList(ctx, project, opts.MaxPageSize)
+		return err
+	})
+	if err != nil {
+		logger.Error("Error getting instances", "error", err)
+		errs = append(errs, fmt.Sprintf("error getting instances: %s", err))
+	}
+
+	var forwardingRuleAggregatedList *compute.ForwardingRuleAggregatedList
+	err = WithRetry(ctx, opts, "getting forwarding rules for "+project, func() (err error) {
+		forwardingRuleAggregatedList, err = client.ForwardingRuleAggregatedList(ctx, project, opts.MaxPageSize)
+		return err
+	})
+	if err != nil {
+		logger.Error("Error getting forwarding rules", "error", err)
+		errs = append(errs, fmt.Sprintf("error getting forwarding rules: %s", err))
+	}
+
+	output := &ProjectResources{
+		Project:            project,
+		AddressList:        addressAggregatedList,
+		InstanceList:       instanceAggregatedList,
+		ForwardingRuleList: forwardingRuleAggregatedList,
+		Errors:             errs,
+	}
+
+	return output
+}
+
+// GetAllServiceProjects gets the IDs of the service projects attached to each host project
+// Host projects are enumerated in parallel, at most opts.BatchHostProjects at a time
+// Host projects whose service projects couldn't be listed are left out, and returned as errors
+func GetAllServiceProjects(ctx context.Context, hostProjects []string, client ComputeClient, opts *Options) (map[string][]string, []string) {
+	type result struct {
+		hostProject string
+		projectIDs  []string
+		err         error
+	}
+
+	ch := make(chan result)
+	sem := make(chan struct{}, workers(opts.BatchHostProjects))
+	var wg sync.WaitGroup
+
+	for _, hostProject := range hostProjects {
+		wg.Add(1)
+		go func(hostProject string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res, err := GetServiceProjects(ctx, hostProject, client, opts)
+			r := result{hostProject: hostProject, err: err}
+			if err == nil {
+				for _, resource := range res.Resources {
+					r.projectIDs = append(r.projectIDs, resource.Id)
+				}
+			}
+			ch <- r
+		}(hostProject)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	serviceProjects := make(map[string][]string)
+	var total int
+	var errs []string
+	for r := range ch {
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: error getting service projects: %s", r.hostProject, r.err))
+			continue
+		}
+		serviceProjects[r.hostProject] = r.projectIDs
+		total += len(r.projectIDs)
+	}
+	sort.Strings(errs)
+
+	NewLogger(opts).Progress("Found service projects", "service_projects", total, "host_projects", len(serviceProjects))
+
+	return serviceProjects, errs
+}
+
+// GetAllResources calls GetResources on each of projectIDs, at most opts.Concurrency at a time
+// Duplicate project IDs are only fetched once
+// fetched, unless nil, is called with each project as it comes in, one at a time, e.g. to checkpoint it
+// Once ctx is cancelled, the projects still being fetched fail right away
+// Projects that fail are returned with their Errors, in project order like the others
+func GetAllResources(ctx context.Context, projectIDs []string, client ComputeClient, opts *Options, fetched func(*ProjectResources)) []*ProjectResources {
+	ch := make(chan *ProjectResources)
+	sem := make(chan struct{}, workers(opts.Concurrency))
+	var wg sync.WaitGroup
+
+	// goroutine for each project to get list of reserved IPs
+	seen := make(map[string]bool)
+	for _, projectID := range projectIDs {
+		if seen[projectID] {
+			NewLogger(opts).Debug("Skipping duplicate service project", "project", projectID)
+			continue
+		}
+		seen[projectID] = true
+		wg.Add(1)
+		go func(projectID string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ch <- GetResources(ctx, projectID, client, opts)
+		}(projectID)
+	}
+
+	// wait for all goroutines to finish and close the channel
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	// gather all responses in output[]
+	var output []*ProjectResources
+	for s := range ch {
+		output = append(output, s)
+		if fetched != nil {
+			fetched(s)
+		}
+	}
+
+	// in project order rather than the order they came in
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Project < output[j].Project
+	})
+
+	return output
+}
+
+// Inventory lists the addresses of the service projects attached to hostProject, by subnet
+// A nil opts stands for DefaultOptions()
+// It fails when the service projects can't be listed. When some of them can't be read,
+// the addresses of the others are returned, along with an error saying how many failed
+func Inventory(ctx context.Context, client ComputeClient, hostProject string, opts *Options) (map[string][]*AddressInfo, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	res, err := GetServiceProjects(ctx, hostProject, client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error getting service projects of %s: %s", hostProject, err)
+	}
+	var projectIDs []string
+	for _, resource := range res.Resources {
+		projectIDs = append(projectIDs, resource.Id)
+	}
+
+	subnets, err := GetSubnets(ctx, hostProject, client, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subnets of %s: %s", hostProject, err)
+	}
+
+	resources := GetAllResources(ctx, projectIDs, client, opts, nil)
+	addressInfoBySubnet := ExtractFields(resources, subnets, opts)
+
+	var failed []string
+	for _, p := range resources {
+		if !p.Complete() {
+			failed = append(failed, p.Project)
+		}
+	}
+	if len(failed) > 0 {
+		return addressInfoBySubnet, fmt.Errorf("%d of %d projects failed, their addresses are missing: %s",
+			len(failed), len(resources), strings.Join(failed, ", "))
+	}
+	return addressInfoBySubnet, nil
+}
@@ -0,0 +1,162 @@
+package gcpips
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+const testSubnetLink = "https://www.googleapis.com/compute/v1/projects/host/regions/us-central1/subnetworks/s1"
+const testNetworkLink = "https://www.googleapis.com/compute/v1/projects/host/global/networks/vpc1"
+
+// fakeClient is a ComputeClient returning canned lists, by project
+// Projects without a list get an empty one
+type fakeClient struct {
+	serviceProjects []string
+	subnets         []*compute.Subnetwork
+	addresses       map[string]*compute.AddressAggregatedList
+	instances       map[string]*compute.InstanceAggregatedList
+}
+
+func (c *fakeClient) AddressAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.AddressAggregatedList, error) {
+	if list, ok := c.addresses[project]; ok {
+		return list, nil
+	}
+	return &compute.AddressAggregatedList{}, nil
+}
+
+func (c *fakeClient) InstanceAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.InstanceAggregatedList, error) {
+	if list, ok := c.instances[project]; ok {
+		return list, nil
+	}
+	return &compute.InstanceAggregatedList{}, nil
+}
+
+func (c *fakeClient) ForwardingRuleAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.ForwardingRuleAggregatedList, error) {
+	return &compute.ForwardingRuleAggregatedList{}, nil
+}
+
+func (c *fakeClient) XpnResources(ctx context.Context, hostProject string) (*compute.ProjectsGetXpnResources, error) {
+	res := &compute.ProjectsGetXpnResources{}
+	for _, project := range c.serviceProjects {
+		res.Resources = append(res.Resources, &compute.XpnResourceId{Id: project, Type: "PROJECT"})
+	}
+	return res, nil
+}
+
+func (c *fakeClient) Subnetworks(ctx context.Context, project string) ([]*compute.Subnetwork, error) {
+	return c.subnets, nil
+}
+
+func TestInventory(t *testing.T) {
+	client := &fakeClient{
+		serviceProjects: []string{"svc"},
+		subnets: []*compute.Subnetwork{
+			{Name: "s1", SelfLink: testSubnetLink, Network: testNetworkLink, IpCidrRange: "10.0.0.0/24"},
+		},
+		addresses: map[string]*compute.AddressAggregatedList{
+			"svc": {Items: map[string]compute.AddressesScopedList{
+				"regions/us-central1": {Addresses: []*compute.Address{
+					// internal reserved addresses don't tell their network, it's looked up by subnet
+					{Address: "10.0.0.5", Status: "RESERVED", AddressType: "INTERNAL", Subnetwork: testSubnetLink},
+					{Address: "10.0.0.2", Status: "IN_USE", AddressType: "INTERNAL", Subnetwork: testSubnetLink,
+						Users: []string{"https://www.googleapis.com/compute/v1/projects/svc/zones/us-central1-a/instances/vm-1"}},
+				}},
+			}},
+		},
+		instances: map[string]*compute.InstanceAggregatedList{
+			"svc": {Items: map[string]compute.InstancesScopedList{
+				"zones/us-central1-a": {Instances: []*compute.Instance{
+					{
+						Name: "vm-1",
+						Zone: "https://www.googleapis.com/compute/v1/projects/svc/zones/us-central1-a",
+						NetworkInterfaces: []*compute.NetworkInterface{{
+							NetworkIP:     "10.0.0.2",
+							Subnetwork:    testSubnetLink,
+							Network:       testNetworkLink,
+							AccessConfigs: []*compute.AccessConfig{{Name: "External NAT", NatIP: "34.1.2.3"}},
+						}},
+					},
+				}},
+			}},
+		},
+	}
+
+	addressesBySubnet, err := Inventory(context.Background(), client, "host", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []string
+	for group := range addressesBySubnet {
+		groups = append(groups, group)
+	}
+	if len(groups) != 1 || groups[0] != "vpc1__s1" {
+		t.Fatalf("groups = %v, want [vpc1__s1]", groups)
+	}
+
+	var rows []string
+	for _, a := range addressesBySubnet["vpc1__s1"] {
+		rows = append(rows, fmt.Sprintf("%s %s %s %s %s %s", a.IP, a.Project, a.Status, a.Type, a.User, a.Subnet))
+	}
+	sort.Strings(rows)
+	want := []string{
+		// the reservation and the instance's interface are merged, keeping the reservation's status
+		"10.0.0.2 svc IN_USE INTERNAL vm-1 vpc1__s1",
+		"10.0.0.5 svc RESERVED INTERNAL  vpc1__s1",
+		"34.1.2.3 svc  EXTERNAL vm-1 vpc1__s1",
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("rows =\n%q\nwant\n%q", rows, want)
+	}
+}
+
+// countingClient is a fakeClient that records the most list calls in flight at the same time
+type countingClient struct {
+	fakeClient
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (c *countingClient) AddressAggregatedList(ctx context.Context, project string, pageSize int64) (*compute.AddressAggregatedList, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.peak {
+		c.peak = c.inFlight
+	}
+	c.mu.Unlock()
+
+	// long enough for the other projects to pile up if they aren't held back
+	time.Sleep(10 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return c.fakeClient.AddressAggregatedList(ctx, project, pageSize)
+}
+
+func TestGetAllResourcesConcurrency(t *testing.T) {
+	var projectIDs []string
+	for i := 0; i < 20; i++ {
+		projectIDs = append(projectIDs, fmt.Sprintf("svc-%02d", i))
+	}
+	for _, concurrency := range []int{1, 3} {
+		client := &countingClient{}
+		resources := GetAllResources(context.Background(), projectIDs, client, &Options{Concurrency: concurrency}, nil)
+		if len(resources) != len(projectIDs) {
+			t.Errorf("concurrency %d: %d projects fetched, want %d", concurrency, len(resources), len(projectIDs))
+		}
+		if client.peak > concurrency {
+			t.Errorf("concurrency %d: %d calls in flight at once", concurrency, client.peak)
+		}
+		if client.peak < 1 {
+			t.Errorf("concurrency %d: no call made", concurrency)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+// Flattening of the fetched lists into one entry per address
+
+package gcpips
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// NoResultsWarning is the warning code of scopes that are simply empty
+const NoResultsWarning = "NO_RESULTS_ON_PAGE"
+
+// Append an AddressInfo object into a map keyed by IP address (see AddressKey)
+// Handle case where the entry already exists
+func insertAddressInfo(addressInfoMap map[string]*AddressInfo, addressInfo *AddressInfo, opts *Options) {
+	ip := AddressKey(addressInfo)
+	// If IP already exists in the map, merge the information together. Existing entries has precedence.
+	// An entry claimed by another resource (a different user or subnet) is kept in the existing
+	// entry's Conflicts, so that the misconfiguration can be reported rather than lost
+	if existingInfo, ok := addressInfoMap[ip]; ok {
+		// conflicts found while merging a project's entries are carried over when merging projects
+		existingInfo.Conflicts = append(existingInfo.Conflicts, addressInfo.Conflicts...)
+		addressInfo.Conflicts = nil
+		if conflicting(existingInfo, addressInfo) {
+			existingInfo.Conflicts = append(existingInfo.Conflicts, addressInfo)
+		}
+		logDiscarded(existingInfo, addressInfo, opts)
+		if existingInfo.Status == "" {
+			existingInfo.Status = addressInfo.Status
+		}
+		if existingInfo.Subnet == "" {
+			existingInfo.Subnet = addressInfo.Subnet
+			existingInfo.SubnetLink = addressInfo.SubnetLink
+		}
+		if existingInfo.Network == "" {
+			existingInfo.Network = addressInfo.Network
+		}
+		if existingInfo.User == "" {
+			existingInfo.User = addressInfo.User
+		}
+		if len(existingInfo.UserLinks) == 0 {
+			existingInfo.UserLinks = addressInfo.UserLinks
+		}
+		if existingInfo.Region == "" {
+			existingInfo.Region = addressInfo.Region
+		}
+		if existingInfo.Label == "" {
+			existingInfo.Label = addressInfo.Label
+		}
+		if existingInfo.Metadata == "" {
+			existingInfo.Metadata = addressInfo.Metadata
+		}
+		if existingInfo.Type == "" {
+			existingInfo.Type = addressInfo.Type
+		}
+		if existingInfo.Purpose == "" {
+			existingInfo.Purpose = addressInfo.Purpose
+		}
+		if existingInfo.Description == "" {
+			existingInfo.Description = addressInfo.Description
+		}
+		if existingInfo.Zone == "" {
+			existingInfo.Zone = addressInfo.Zone
+		}
+		if existingInfo.Location == "" {
+			existingInfo.Location = addressInfo.Location
+		}
+		if existingInfo.AccessConfig == "" {
+			existingInfo.AccessConfig = addressInfo.AccessConfig
+		}
+		if existingInfo.Created == "" {
+			existingInfo.Created = addressInfo.Created
+		}
+		if existingInfo.Reserved == "" {
+			existingInfo.Reserved = addressInfo.Reserved
+		}
+	} else {
+		addressInfoMap[ip] = addressInfo
+	}
+}
+
+// Log the status, subnet and user of addressInfo that differ from the ones of existingInfo,
+// which are kept when merging them
+func logDiscarded(existingInfo *AddressInfo, addressInfo *AddressInfo, opts *Options) {
+	logger := NewLogger(opts)
+	fields := []struct {
+		name     string
+		existing string
+		new      string
+	}{
+		{"status", existingInfo.Status, addressInfo.Status},
+		{"subnet", existingInfo.Subnet, addressInfo.Subnet},
+		{"user", existingInfo.User, addressInfo.User},
+	}
+	for _, f := range fields {
+		if f.existing != "" && f.new != "" && f.existing != f.new {
+			logger.Debug("Merging entries, discarding a value", "ip", existingInfo.IP, "field", f.name,
+				"kept", f.existing, "kept_project", existingInfo.Project,
+				"discarded", f.new, "discarded_project", addressInfo.Project)
+		}
+	}
+}
+
+// Whether two entries for the same IP are claimed by different resources
+// A reserved address lists all its users (e.g. the forwarding rules sharing a load balancer IP),
+// so an entry whose user is one of them is the same claim, seen from the user's side
+func conflicting(a *AddressInfo, b *AddressInfo) bool {
+	if a.Subnet != "" && b.Subnet != "" && a.Subnet != b.Subnet {
+		return true
+	}
+	if a.User == "" || b.User == "" {
+		return false
+	}
+	return !sameUser(a.User, b.User) && !sameUser(b.User, a.User)
+}
+
+// Whether user is one of the comma-separated users
+func sameUser(users string, user string) bool {
+	for _, u := range strings.Split(users, ", ") {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Value of key in instance metadata, "" when it isn't set
+func metadataValue(metadata *compute.Metadata, key string) string {
+	if metadata == nil || key == "" {
+		return ""
+	}
+	for _, item := range metadata.Items {
+		if item.Key == key && item.Value != nil {
+			return *item.Value
+		}
+	}
+	return ""
+}
+
+// GetName parses a self-link to get just the resource name at the end
+// Trailing slashes are ignored, and an empty (or all-slash) self-link has no name ("")
+func GetName(selfLink string) string {
+	selfLink = strings.TrimRight(selfLink, "/")
+	if selfLink == "" {
+		return ""
+	}
+	return selfLink[strings.LastIndex(selfLink, "/")+1:]
+}
+
+// ParseScope parses an aggregated list scope key such as "regions/us-central1" or "zones/us-central1-a"
+// into its kind ("regions", "zones") and location name ("us-central1", "us-central1-a")
+// The "global" key, and any other key without a prefix, is both kind and name
+// Only the last two path segments count, so longer prefixes are ignored
+func ParseScope(key string) (kind string, name string) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	name = parts[len(parts)-1]
+	if len(parts) < 2 {
+		return name, name
+	}
+	return parts[len(parts)-2], name
+}
+
+// Flatten processes a list of ProjectResources, where each projectResource includes a list of all
+// Address and Instance resources in the project.
+// Returns a map of AddressInfo objects, whose keys are IP addresses
+// Projects are flattened in parallel (opts.FlattenConcurrency at a time) into maps of their own,
+// which are then merged in project order, so that the first project with an IP takes precedence
+func Flatten(projectResourceList []*ProjectResources, opts *Options) map[string]*AddressInfo {
+	sorted := make([]*ProjectResources, len(projectResourceList))
+	copy(sorted, projectResourceList)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Project < sorted[j].Project
+	})
+
+	shards := make([]map[string]*AddressInfo, len(sorted))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers(opts.FlattenConcurrency); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				shards[i] = make(map[string]*AddressInfo)
+				flattenProject(sorted[i], opts, shards[i])
+			}
+		}()
+	}
+	for i := range sorted {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	addressInfoMap := make(map[string]*AddressInfo)
+	for _, shard := range shards {
+		for _, addressInfo := range shard {
+			insertAddressInfo(addressInfoMap, addressInfo, opts)
+		}
+	}
+	return addressInfoMap
+}
+
+// Add the AddressInfo objects of a single project to addressInfoMap
+func flattenProject(p *ProjectResources, opts *Options, addressInfoMap map[string]*AddressInfo) {
+	logger := ProjectLogger(p.Project, opts)
+	if p.AddressList == nil {
+		logger.Progress("No reserved addresses")
+	} else {
+		// scopes in order, so that conflicting entries are always merged the same way
+		var scopes []string
+		for scope := range p.AddressList.Items {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			_, location := ParseScope(scope)
+			addressScopedList := p.AddressList.Items[scope]
+			if addressScopedList.Addresses != nil {
+				for _, address := range addressScopedList.Addresses {
+					// all users, e.g. the forwarding rules sharing a load balancer IP
+					// (none when the reserved IP is RESERVED but not IN_USE)
+					var users []string
+					for _, userLink := range address.Users {
+						users = append(users, GetName(userLink))
+					}
+					user := strings.Join(users, ", ")
+					for _, ip := range rangeIPs(address.Address, address.PrefixLength, opts.ExpandRanges) {
+						insertAddressInfo(addressInfoMap, &AddressInfo{
+							Project:     p.Project,
+							IP:          ip,
+							Status:      address.Status,
+							Subnet:      SubnetKey(address.Subnetwork, "", opts),
+							SubnetLink:  address.Subnetwork,
+							Network:     GetName(address.Network),
+							User:        user,
+							UserLinks:   address.Users,
+							Region:      GetName(address.Region),
+							VPC:         ResourceProject(address.Subnetwork),
+							Label:       address.Labels[opts.LabelColumn],
+							Type:        addressType(address),
+							Purpose:     address.Purpose,
+							Description: address.Description,
+							Reserved:    address.CreationTimestamp,
+							Location:    location,
+						}, opts)
+					}
+				}
+			}
+		}
+	}
+	if p.InstanceList == nil {
+		logger.Progress("No instances")
+	} else {
+		var scopes []string
+		for scope := range p.InstanceList.Items {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			_, location := ParseScope(scope)
+			instanceScopedList := p.InstanceList.Items[scope]
+			if instanceScopedList.Instances != nil {
+				for _, instance := range instanceScopedList.Instances {
+					if instance == nil {
+						continue
+					}
+					// fields shared by all entries of the instance
+					base := AddressInfo{
+						Project:  p.Project,
+						User:     instance.Name,
+						Label:    instance.Labels[opts.LabelColumn],
+						Metadata: metadataValue(instance.Metadata, opts.MetadataColumn),
+						Zone:     GetName(instance.Zone),
+						Location: location,
+						Created:  instance.CreationTimestamp,
+					}
+					// one entry per network interface, none for an instance without any
+					for _, nic := range instance.NetworkInterfaces {
+						if nic == nil {
+							continue
+						}
+						if nic.NetworkIP != "" {
+							addressInfo := base
+							addressInfo.IP = nic.NetworkIP
+							addressInfo.Type = "INTERNAL"
+							setSubnet(&addressInfo, nic, opts)
+							addressInfo.VPC = ResourceProject(nic.Subnetwork)
+							insertAddressInfo(addressInfoMap, &addressInfo, opts)
+						}
+						insertExternalAddressInfo(addressInfoMap, base, nic, opts)
+						if opts.IncludeIP6 {
+							insertIPv6AddressInfo(addressInfoMap, base, nic, opts)
+						}
+						if opts.IncludeAliases {
+							insertAliasAddressInfo(addressInfoMap, base, nic, opts)
+						}
+					}
+				}
+			}
+		}
+	}
+	if p.ForwardingRuleList != nil {
+		var scopes []string
+		for scope := range p.ForwardingRuleList.Items {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			_, location := ParseScope(scope)
+			for _, rule := range p.ForwardingRuleList.Items[scope].ForwardingRules {
+				if rule == nil || rule.IPAddress == "" {
+					continue
+				}
+				addressInfo := &AddressInfo{
+					Project:    p.Project,
+					IP:         rule.IPAddress,
+					Subnet:     SubnetKey(rule.Subnetwork, "", opts),
+					SubnetLink: rule.Subnetwork,
+					Network:    GetName(rule.Network),
+					User:       rule.Name,
+					Label:      rule.Labels[opts.LabelColumn],
+					Type:       forwardingRuleType(rule),
+					Location:   location,
+					Region:     GetName(rule.Region),
+				}
+				if addressInfo.Type != "EXTERNAL" {
+					addressInfo.VPC = ResourceProject(rule.Subnetwork)
+				}
+				insertAddressInfo(addressInfoMap, addressInfo, opts)
+			}
+		}
+	}
+}
+
+// Type of the IP of a forwarding rule: "ILB VIP" for internal load balancers, EXTERNAL otherwise
+func forwardingRuleType(rule *compute.ForwardingRule) string {
+	if strings.HasPrefix(rule.LoadBalancingScheme, "INTERNAL") {
+		return "ILB VIP"
+	}
+	return "EXTERNAL"
+}
+
+// Type of a reserved address: its address type (INTERNAL or EXTERNAL), or "ILB VIP" for the
+// virtual IP of an internal load balancer, i.e. an internal address reserved for sharing between
+// load balancers or used by a forwarding rule
+func addressType(address *compute.Address) string {
+	if address.Purpose == "SHARED_LOADBALANCER_VIP" {
+		return "ILB VIP"
+	}
+	if address.AddressType == "INTERNAL" {
+		for _, user := range address.Users {
+			if strings.Contains(user, "/forwardingRules/") {
+				return "ILB VIP"
+			}
+		}
+	}
+	return address.AddressType
+}
+
+// Set the subnet and network of an entry of an instance's network interface
+// The subnet isn't qualified with its network yet, see ExtractFields
+func setSubnet(addressInfo *AddressInfo, nic *compute.NetworkInterface, opts *Options) {
+	addressInfo.Subnet = SubnetKey(nic.Subnetwork, "", opts)
+	addressInfo.SubnetLink = nic.Subnetwork
+	addressInfo.Network = GetName(nic.Network)
+}
+
+// Add an entry for the external IP of each access config of an instance's network interface
+// External IPs are listed in the subnet of the interface, tagged with the name of their access config
+// Ephemeral IPs are never in the list of reserved addresses, so this is the only place they're found
+// An access config has no IP while its instance is stopped, unless the IP is reserved
+// base holds the fields shared by all entries of the instance
+func insertExternalAddressInfo(addressInfoMap map[string]*AddressInfo, base AddressInfo, nic *compute.NetworkInterface, opts *Options) {
+	for _, accessConfig := range nic.AccessConfigs {
+		if accessConfig == nil || accessConfig.NatIP == "" {
+			continue
+		}
+		addressInfo := base
+		addressInfo.IP = accessConfig.NatIP
+		addressInfo.Type = "EXTERNAL"
+		setSubnet(&addressInfo, nic, opts)
+		addressInfo.AccessConfig = accessConfig.Name
+		insertAddressInfo(addressInfoMap, &addressInfo, opts)
+	}
+}
+
+// Add entries for the IPv6 addresses of a dual-stack instance's network interface
+// Internal IPv6 addresses are on the interface itself, external ones are on its IPv6 access configs
+// base holds the fields shared by all entries of the instance
+func insertIPv6AddressInfo(addressInfoMap map[string]*AddressInfo, base AddressInfo, nic *compute.NetworkInterface, opts *Options) {
+	if nic.Ipv6Address != "" {
+		addressInfo := base
+		addressInfo.IP = nic.Ipv6Address
+		addressInfo.Type = "INTERNAL"
+		setSubnet(&addressInfo, nic, opts)
+		addressInfo.VPC = ResourceProject(nic.Subnetwork)
+		insertAddressInfo(addressInfoMap, &addressInfo, opts)
+	}
+	for _, accessConfig := range nic.Ipv6AccessConfigs {
+		if accessConfig == nil || accessConfig.ExternalIpv6 == "" {
+			continue
+		}
+		addressInfo := base
+		addressInfo.IP = accessConfig.ExternalIpv6
+		addressInfo.Type = "EXTERNAL"
+		setSubnet(&addressInfo, nic, opts)
+		insertAddressInfo(addressInfoMap, &addressInfo, opts)
+	}
+}
+
+// Add an entry for each alias IP range of an instance's network interface, e.g. the pod range of a GKE node
+// The IP of the entry is the range itself (a CIDR, e.g. 10.4.1.0/24), which sorts by its network address
+// base holds the fields shared by all entries of the instance
+func insertAliasAddressInfo(addressInfoMap map[string]*AddressInfo, base AddressInfo, nic *compute.NetworkInterface, opts *Options) {
+	for _, aliasRange := range nic.AliasIpRanges {
+		if aliasRange == nil || aliasRange.IpCidrRange == "" {
+			continue
+		}
+		addressInfo := base
+		addressInfo.IP = aliasRange.IpCidrRange
+		addressInfo.Type = "ALIAS"
+		addressInfo.RangeName = aliasRange.SubnetworkRangeName
+		setSubnet(&addressInfo, nic, opts)
+		addressInfo.VPC = ResourceProject(nic.Subnetwork)
+		insertAddressInfo(addressInfoMap, &addressInfo, opts)
+	}
+}
+
+// Split a comma-separated flag value into a set of upper-cased values
+func splitList(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range strings.Split(list, ",") {
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// EffectiveStatus is the status used by the status filters
+// Entries derived from instances have no status, but their IP is in use
+func EffectiveStatus(addressInfo *AddressInfo) string {
+	if addressInfo.Status == "" {
+		return "IN_USE"
+	}
+	return addressInfo.Status
+}
+
+// ExtractFields processes a list of ProjectResources and re-organizes it by subnet (see SubnetKey),
+// or by another key with opts.GroupBy
+// subnets (from GetSubnets) give the network of the entries whose resource doesn't tell it,
+// e.g. internal reserved addresses
+// Entries with one of opts.ExcludeStatus, or not one of opts.Status, are dropped
+func ExtractFields(projectResourceList []*ProjectResources, subnets map[string]*compute.Subnetwork, opts *Options) map[string][]*AddressInfo {
+	networks := make(map[string]string)
+	for _, subnetwork := range subnets {
+		networks[resourcePath(subnetwork.SelfLink)] = GetName(subnetwork.Network)
+	}
+
+	addressInfoByGroup := make(map[string][]*AddressInfo)
+	addressInfoByIP := Flatten(projectResourceList, opts)
+	includeStatus := splitList(opts.Status)
+	excludeStatus := splitList(opts.ExcludeStatus)
+	for _, addressInfo := range addressInfoByIP {
+		status := EffectiveStatus(addressInfo)
+		if excludeStatus[status] || (len(includeStatus) > 0 && !includeStatus[status]) {
+			continue
+		}
+		for _, a := range append([]*AddressInfo{addressInfo}, addressInfo.Conflicts...) {
+			qualifySubnet(a, networks, opts)
+		}
+		group := GroupKey(addressInfo, opts)
+		addressInfoByGroup[group] = append(addressInfoByGroup[group], addressInfo)
+	}
+	return addressInfoByGroup
+}
+
+// Qualify the subnet of an entry with its network, looking the network up in networks
+// (by subnet path) when the entry doesn't have it
+func qualifySubnet(addressInfo *AddressInfo, networks map[string]string, opts *Options) {
+	if addressInfo.SubnetLink == "" {
+		return
+	}
+	if addressInfo.Network == "" {
+		addressInfo.Network = networks[resourcePath(addressInfo.SubnetLink)]
+	}
+	addressInfo.Subnet = SubnetKey(addressInfo.SubnetLink, addressInfo.Network, opts)
+}
@@ -0,0 +1,48 @@
+package gcpips
+
+import (
+	"testing"
+)
+
+func TestGetName(t *testing.T) {
+	tests := []struct {
+		selfLink string
+		want     string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"///", ""},
+		{"subnet-1", "subnet-1"},
+		{"projects/host/regions/us-central1/subnetworks/subnet-1", "subnet-1"},
+		{"https://www.googleapis.com/compute/v1/projects/host/regions/us-central1/subnetworks/subnet-1", "subnet-1"},
+		{"projects/host/regions/us-central1/subnetworks/subnet-1/", "subnet-1"},
+		{"projects/host/regions/us-central1/subnetworks/subnet-1//", "subnet-1"},
+	}
+	for _, test := range tests {
+		if got := GetName(test.selfLink); got != test.want {
+			t.Errorf("GetName(%q) = %q, want %q", test.selfLink, got, test.want)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		key      string
+		wantKind string
+		wantName string
+	}{
+		{"regions/us-central1", "regions", "us-central1"},
+		{"zones/us-central1-a", "zones", "us-central1-a"},
+		{"global", "global", "global"},
+		{"", "", ""},
+		// only the last two segments count
+		{"projects/svc/zones/us-central1-a", "zones", "us-central1-a"},
+		{"/regions/europe-west1/", "regions", "europe-west1"},
+	}
+	for _, test := range tests {
+		kind, name := ParseScope(test.key)
+		if kind != test.wantKind || name != test.wantName {
+			t.Errorf("ParseScope(%q) = %q, %q, want %q, %q", test.key, kind, name, test.wantKind, test.wantName)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Keys of subnets and addresses
+//
+// Subnets of different networks can have the same name, so subnets are named
+// after their network as well (network__subnet). The same goes for subnets of
+// different host projects, so with more than one host project they are named
+// after their host project too (host-project__network__subnet), and internal
+// IPs are told apart by the host project of their subnet.
+
+package gcpips
+
+import (
+	"strings"
+)
+
+// HostSubnetSeparator separates the host project, network and subnet name of a qualified subnet name
+const HostSubnetSeparator = "__"
+
+// SubnetKey is the name a subnet is listed under, from its self-link and the name of its network
+// The subnet name, qualified with its network unless that's unknown (""),
+// and with its project with opts.QualifySubnets
+func SubnetKey(selfLink string, network string, opts *Options) string {
+	name := GetName(selfLink)
+	if name == "" {
+		return name
+	}
+	if network != "" {
+		name = network + HostSubnetSeparator + name
+	}
+	if opts.QualifySubnets {
+		name = ResourceProject(selfLink) + HostSubnetSeparator + name
+	}
+	return name
+}
+
+// AddressKey is the key of an entry in the map of all addresses
+// Internal IPs are only unique within a VPC, so they are keyed by the project of their
+// subnet (the host project in a shared VPC) as well
+func AddressKey(addressInfo *AddressInfo) string {
+	if addressInfo.VPC == "" {
+		return addressInfo.IP
+	}
+	return addressInfo.VPC + "@" + addressInfo.IP
+}
+
+// Groupings of the addresses, see Options.GroupBy
+const (
+	GroupBySubnet  = "subnet"
+	GroupByProject = "project"
+	GroupByNetwork = "network"
+)
+
+// UnassignedGroup is the group of the entries without a subnet (or network, with GroupByNetwork),
+// e.g. global and regional external addresses, so that they are listed rather than dropped
+// Subnets are named after their network, so no subnet is listed under this name
+const UnassignedGroup = "unassigned"
+
+// GroupKey is the key of the group an entry is listed in, by opts.GroupBy: its subnet
+// (the default, for ""), its project, or its network (qualified like subnets with opts.QualifySubnets)
+// Entries without a subnet (or network) are in UnassignedGroup when grouping by subnet (or network)
+func GroupKey(addressInfo *AddressInfo, opts *Options) string {
+	key := addressInfo.Subnet
+	switch opts.GroupBy {
+	case GroupByProject:
+		return addressInfo.Project
+	case GroupByNetwork:
+		key = addressInfo.Network
+		// the host project is the one of the subnet, unknown for the IPs of resources without one
+		if project := ResourceProject(addressInfo.SubnetLink); opts.QualifySubnets && project != "" && key != "" {
+			key = project + HostSubnetSeparator + key
+		}
+	}
+	if key == "" {
+		return UnassignedGroup
+	}
+	return key
+}
+
+// Path of a resource from its self-link, e.g. projects/my-project/regions/us-central1/subnetworks/subnet-1,
+// so that self-links with different API versions or hosts compare equal
+func resourcePath(selfLink string) string {
+	if i := strings.Index(selfLink, "projects/"); i >= 0 {
+		return selfLink[i:]
+	}
+	return selfLink
+}
+
+// ResourceProject is the project ID in a full resource name, e.g. my-project in
+// //compute.googleapis.com/projects/my-project/zones/us-central1-a/instances/vm-1
+func ResourceProject(name string) string {
+	parts := strings.Split(name, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
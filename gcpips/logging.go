@@ -0,0 +1,51 @@
+// Loggers for work that runs concurrently for many projects
+//
+// Projects are fetched and processed in parallel, so their log lines
+// interleave. Each line has the project it is about as a "project" attribute,
+// which keeps the output greppable by project: grep 'project=my-project'
+//
+// Lines are logged with log/slog, through slog.Default(), so that a command
+// can choose their format (e.g. JSON) with slog.SetDefault. On top of the
+// slog levels, progress (a line per project or file) is left out with
+// Options.Quiet, and diagnostics (merges, API call timing) are only logged
+// with Options.Verbose.
+
+package gcpips
+
+import (
+	"log/slog"
+)
+
+// Logger is a slog.Logger that leaves out lines above the verbosity of the options
+// Info, Warn and Error always log, for summaries, warnings and errors
+type Logger struct {
+	*slog.Logger
+	quiet   bool
+	verbose bool
+}
+
+// NewLogger returns a Logger writing to slog.Default(), at the verbosity of opts
+func NewLogger(opts *Options) *Logger {
+	return &Logger{Logger: slog.Default(), quiet: opts.Quiet, verbose: opts.Verbose}
+}
+
+// ProjectLogger returns a Logger whose lines have the attribute project=project
+func ProjectLogger(project string, opts *Options) *Logger {
+	logger := NewLogger(opts)
+	logger.Logger = logger.With("project", project)
+	return logger
+}
+
+// Progress logs progress at the info level, e.g. a line per project or file, unless the options are quiet
+func (l *Logger) Progress(msg string, args ...any) {
+	if !l.quiet {
+		l.Info(msg, args...)
+	}
+}
+
+// Debug logs diagnostics at the debug level, only when the options are verbose
+func (l *Logger) Debug(msg string, args ...any) {
+	if l.verbose {
+		l.Logger.Debug(msg, args...)
+	}
+}
@@ -0,0 +1,48 @@
+// Settings of a scan
+
+package gcpips
+
+// Options holds the settings of a scan
+// The zero value lists all IPv4 addresses, with a single request at a time and no retries
+type Options struct {
+	IncludeIP6     bool   // also list the IPv6 addresses of dual-stack instances
+	IncludeAliases bool   // also list the alias IP ranges of instances, e.g. the pod ranges of GKE nodes
+	LabelColumn    string // instance/address label whose value goes in AddressInfo.Label
+	MetadataColumn string // instance metadata key whose value goes in AddressInfo.Metadata
+	ExpandRanges   bool   // list small reserved ranges address by address, see MaxExpandedRange
+	Status         string // comma-separated statuses to list, all when empty
+	ExcludeStatus  string // comma-separated statuses to leave out
+	Verbose        bool   // log diagnostics: the values discarded when merging entries for the same IP, API call timing
+	Quiet          bool   // log only errors, warnings and summaries, not a line per project
+	GroupBy        string // what ExtractFields groups addresses by, GroupBySubnet ("" too), GroupByProject or GroupByNetwork
+
+	MaxPageSize        int64        // results per page of the list calls, 1-MaxPageSizeLimit, 0 for the API default
+	MaxRetries         int          // retries of an API call failing with one of RetryCodes
+	RetryCodes         map[int]bool // HTTP status codes of API errors to retry, see ParseStatusCodes
+	BatchHostProjects  int          // host projects whose service projects are listed at the same time
+	Concurrency        int          // service projects fetched at the same time
+	FlattenConcurrency int          // projects whose lists are flattened at the same time
+
+	// whether subnet names are qualified with their host project, see SubnetKey
+	QualifySubnets bool
+}
+
+// DefaultOptions returns the settings the gcp-ips command uses unless told otherwise
+func DefaultOptions() *Options {
+	retryCodes, _ := ParseStatusCodes(DefaultRetryOn)
+	return &Options{
+		MaxRetries:         DefaultMaxRetries,
+		RetryCodes:         retryCodes,
+		BatchHostProjects:  5,
+		Concurrency:        10,
+		FlattenConcurrency: 1,
+	}
+}
+
+// Number of goroutines for a concurrency setting, at least one so that the work gets done
+func workers(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,179 @@
+// Pagination of the list calls
+//
+// List calls return at most a page of results (500 by default, see
+// Options.MaxPageSize) and a token for the next page. These helpers follow the
+// tokens and merge all pages into a single list. Aggregated lists are keyed
+// by scope, and a scope can show up on several pages, so the resources of a
+// scope are appended rather than replaced.
+
+package gcpips
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Whether the warning of a scope on a new page should replace the one of the previous pages
+// A real warning (e.g. unreachable region) sticks, the "no results" one doesn't
+func replaceWarning(code string) bool {
+	return code == "" || code == NoResultsWarning
+}
+
+// Get all pages of an aggregated list of addresses
+func allAddressPages(ctx context.Context, call *compute.AddressesAggregatedListCall) (*compute.AddressAggregatedList, error) {
+	var list *compute.AddressAggregatedList
+	err := call.Pages(ctx, func(page *compute.AddressAggregatedList) error {
+		if list == nil {
+			list = page
+			return nil
+		}
+		if list.Items == nil {
+			list.Items = page.Items
+			return nil
+		}
+		for scope, scopedList := range page.Items {
+			merged, ok := list.Items[scope]
+			if !ok {
+				list.Items[scope] = scopedList
+				continue
+			}
+			merged.Addresses = append(merged.Addresses, scopedList.Addresses...)
+			if merged.Warning == nil || replaceWarning(merged.Warning.Code) {
+				merged.Warning = scopedList.Warning
+			}
+			list.Items[scope] = merged
+		}
+		return nil
+	})
+	if list != nil {
+		list.NextPageToken = ""
+	}
+	return list, err
+}
+
+// Get all pages of an aggregated list of instances
+func allInstancePages(ctx context.Context, call *compute.InstancesAggregatedListCall) (*compute.InstanceAggregatedList, error) {
+	var list *compute.InstanceAggregatedList
+	err := call.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+		if list == nil {
+			list = page
+			return nil
+		}
+		if list.Items == nil {
+			list.Items = page.Items
+			return nil
+		}
+		for scope, scopedList := range page.Items {
+			merged, ok := list.Items[scope]
+			if !ok {
+				list.Items[scope] = scopedList
+				continue
+			}
+			merged.Instances = append(merged.Instances, scopedList.Instances...)
+			if merged.Warning == nil || replaceWarning(merged.Warning.Code) {
+				merged.Warning = scopedList.Warning
+			}
+			list.Items[scope] = merged
+		}
+		return nil
+	})
+	if list != nil {
+		list.NextPageToken = ""
+	}
+	return list, err
+}
+
+// Get all pages of an aggregated list of forwarding rules
+func allForwardingRulePages(ctx context.Context, call *compute.ForwardingRulesAggregatedListCall) (*compute.ForwardingRuleAggregatedList, error) {
+	var list *compute.ForwardingRuleAggregatedList
+	err := call.Pages(ctx, func(page *compute.ForwardingRuleAggregatedList) error {
+		if list == nil {
+			list = page
+			return nil
+		}
+		if list.Items == nil {
+			list.Items = page.Items
+			return nil
+		}
+		for scope, scopedList := range page.Items {
+			merged, ok := list.Items[scope]
+			if !ok {
+				list.Items[scope] = scopedList
+				continue
+			}
+			merged.ForwardingRules = append(merged.ForwardingRules, scopedList.ForwardingRules...)
+			if merged.Warning == nil || replaceWarning(merged.Warning.Code) {
+				merged.Warning = scopedList.Warning
+			}
+			list.Items[scope] = merged
+		}
+		return nil
+	})
+	if list != nil {
+		list.NextPageToken = ""
+	}
+	return list, err
+}
+
+// Get all pages of the resources (service projects) of a shared VPC host project
+func allXpnResourcePages(ctx context.Context, call *compute.ProjectsGetXpnResourcesCall) (*compute.ProjectsGetXpnResources, error) {
+	var res *compute.ProjectsGetXpnResources
+	err := call.Pages(ctx, func(page *compute.ProjectsGetXpnResources) error {
+		if res == nil {
+			res = page
+			return nil
+		}
+		res.Resources = append(res.Resources, page.Resources...)
+		return nil
+	})
+	if res != nil {
+		res.NextPageToken = ""
+	}
+	return res, err
+}
+
+// Get all subnets of an aggregated list of subnets
+func allSubnetworkPages(ctx context.Context, call *compute.SubnetworksAggregatedListCall) ([]*compute.Subnetwork, error) {
+	var subnetworks []*compute.Subnetwork
+	err := call.Pages(ctx, func(page *compute.SubnetworkAggregatedList) error {
+		for _, scopedList := range page.Items {
+			subnetworks = append(subnetworks, scopedList.Subnetworks...)
+		}
+		return nil
+	})
+	return subnetworks, err
+}
+
+// AllPublicDelegatedPrefixPages gets all prefixes of an aggregated list of public delegated prefixes
+func AllPublicDelegatedPrefixPages(ctx context.Context, call *compute.PublicDelegatedPrefixesAggregatedListCall) ([]*compute.PublicDelegatedPrefix, error) {
+	var prefixes []*compute.PublicDelegatedPrefix
+	err := call.Pages(ctx, func(page *compute.PublicDelegatedPrefixAggregatedList) error {
+		for _, scopedList := range page.Items {
+			prefixes = append(prefixes, scopedList.PublicDelegatedPrefixes...)
+		}
+		return nil
+	})
+	return prefixes, err
+}
+
+// AllNodeGroupPages gets all node groups of an aggregated list of node groups, keyed by scope
+func AllNodeGroupPages(ctx context.Context, call *compute.NodeGroupsAggregatedListCall) (map[string][]*compute.NodeGroup, error) {
+	nodeGroups := make(map[string][]*compute.NodeGroup)
+	err := call.Pages(ctx, func(page *compute.NodeGroupAggregatedList) error {
+		for scope, scopedList := range page.Items {
+			nodeGroups[scope] = append(nodeGroups[scope], scopedList.NodeGroups...)
+		}
+		return nil
+	})
+	return nodeGroups, err
+}
+
+// AllNodePages gets all nodes of a node group
+func AllNodePages(ctx context.Context, call *compute.NodeGroupsListNodesCall) ([]*compute.NodeGroupNode, error) {
+	var nodes []*compute.NodeGroupNode
+	err := call.Pages(ctx, func(page *compute.NodeGroupsListNodes) error {
+		nodes = append(nodes, page.Items...)
+		return nil
+	})
+	return nodes, err
+}
@@ -0,0 +1,112 @@
+package gcpips
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// A Compute API service whose aggregated lists come from pages, in order,
+// following the page tokens: page i links to page i+1 with the token "i+1"
+func pagedService(t *testing.T, pages []interface{}) *compute.Service {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			i = int(token[0] - '0')
+		}
+		if i >= len(pages) {
+			t.Errorf("unexpected page token %q", r.URL.Query().Get("pageToken"))
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pages[i])
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := compute.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestAllAddressPages(t *testing.T) {
+	service := pagedService(t, []interface{}{
+		&compute.AddressAggregatedList{
+			NextPageToken: "1",
+			Items: map[string]compute.AddressesScopedList{
+				"regions/us-central1":  {Addresses: []*compute.Address{{Name: "a1"}}},
+				"regions/europe-west1": {Warning: &compute.AddressesScopedListWarning{Code: NoResultsWarning}},
+			},
+		},
+		// the second page repeats a scope of the first one
+		&compute.AddressAggregatedList{
+			Items: map[string]compute.AddressesScopedList{
+				"regions/us-central1":  {Addresses: []*compute.Address{{Name: "a2"}}},
+				"regions/europe-west1": {Addresses: []*compute.Address{{Name: "a3"}}},
+			},
+		},
+	})
+
+	list, err := allAddressPages(context.Background(), service.Addresses.AggregatedList("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"regions/us-central1":  {"a1", "a2"},
+		"regions/europe-west1": {"a3"},
+	}
+	for scope, want := range tests {
+		var got []string
+		for _, address := range list.Items[scope].Addresses {
+			got = append(got, address.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: addresses %v, want %v", scope, got, want)
+		}
+	}
+	if list.NextPageToken != "" {
+		t.Errorf("NextPageToken = %q, want none", list.NextPageToken)
+	}
+}
+
+func TestAllInstancePages(t *testing.T) {
+	service := pagedService(t, []interface{}{
+		&compute.InstanceAggregatedList{
+			NextPageToken: "1",
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-central1-a": {Instances: []*compute.Instance{{Name: "vm-1"}}},
+			},
+		},
+		&compute.InstanceAggregatedList{
+			NextPageToken: "2",
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-central1-a": {Instances: []*compute.Instance{{Name: "vm-2"}}},
+			},
+		},
+		&compute.InstanceAggregatedList{
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-central1-a": {Instances: []*compute.Instance{{Name: "vm-3"}}},
+			},
+		},
+	})
+
+	list, err := allInstancePages(context.Background(), service.Instances.AggregatedList("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, instance := range list.Items["zones/us-central1-a"].Instances {
+		got = append(got, instance.Name)
+	}
+	if fmt.Sprint(got) != "[vm-1 vm-2 vm-3]" {
+		t.Errorf("instances %v, want [vm-1 vm-2 vm-3]", got)
+	}
+}
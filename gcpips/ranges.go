@@ -0,0 +1,51 @@
+// Reserved ranges (addresses with a prefix length), e.g. for private services access
+
+package gcpips
+
+import (
+	"fmt"
+	"net"
+)
+
+// MaxExpandedRange is the largest range (a /28 for IPv4) listed address by address with Options.ExpandRanges
+const MaxExpandedRange = 16
+
+// IPs to list for a reserved address
+// Single addresses are listed as is. With expand, small ranges are listed address
+// by address and larger ones as a single CIDR, to keep the output a reasonable size
+// Without expand, ranges are listed by their first address, as before
+func rangeIPs(address string, prefixLength int64, expand bool) []string {
+	if prefixLength == 0 || !expand {
+		return []string{address}
+	}
+
+	cidr := fmt.Sprintf("%s/%d", address, prefixLength)
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return []string{address}
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if hostBits := bits - ones; hostBits >= 32 || 1<<uint(hostBits) > MaxExpandedRange {
+		return []string{ipNet.String()}
+	}
+
+	var ips []string
+	for ip := ip.Mask(ipNet.Mask); ipNet.Contains(ip); ip = nextIP(ip) {
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+// The IP following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
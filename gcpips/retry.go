@@ -0,0 +1,83 @@
+// Retrying of API calls that fail with transient errors
+
+package gcpips
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultRetryOn lists the status codes retried by default: rate limiting and server errors
+const DefaultRetryOn = "429,500,502,503"
+
+// DefaultMaxRetries is the number of retries of a failed API call before giving up
+const DefaultMaxRetries = 2
+
+// Delay before the first retry of a failed API call, doubled for every further retry
+const retryDelay = time.Second
+
+// Longest delay between two attempts at an API call
+const maxRetryDelay = 30 * time.Second
+
+// ParseStatusCodes parses a comma-separated list of HTTP status codes, e.g. DefaultRetryOn
+func ParseStatusCodes(list string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q", value)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// Whether err is an API error with one of the given status codes
+func retryable(err error, codes map[int]bool) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && codes[apiErr.Code]
+}
+
+// Delay before the given retry (1 for the first one): exponential, capped at maxRetryDelay,
+// with a random half of it as jitter so that concurrent calls don't all retry at once
+func backoff(retry int) time.Duration {
+	delay := maxRetryDelay
+	if retry < 16 {
+		if d := retryDelay << uint(retry-1); d < maxRetryDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// WithRetry calls an API, retrying up to opts.MaxRetries times when it fails with one of opts.RetryCodes
+// Other errors are returned right away, and so is the last error once ctx is done
+func WithRetry(ctx context.Context, opts *Options, what string, call func() error) error {
+	logger := NewLogger(opts)
+	var err error
+	for retry := 1; ; retry++ {
+		start := time.Now()
+		err = call()
+		logger.Debug("API call", "call", what, "duration", time.Since(start).Round(time.Millisecond))
+		if err == nil || !retryable(err, opts.RetryCodes) || retry > opts.MaxRetries {
+			return err
+		}
+		delay := backoff(retry)
+		logger.Warn("API call failed, retrying", "call", what, "delay", delay.Round(time.Millisecond), "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package gcpips
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// A call failing with the given errors, one per attempt, then succeeding
+type flakyCall struct {
+	errs     []error
+	attempts int
+}
+
+func (c *flakyCall) call() error {
+	c.attempts++
+	if c.attempts <= len(c.errs) {
+		return c.errs[c.attempts-1]
+	}
+	return nil
+}
+
+func TestWithRetry(t *testing.T) {
+	opts := DefaultOptions()
+	tests := []struct {
+		name     string
+		errs     []error
+		wantErr  bool
+		attempts int
+	}{
+		{"success", nil, false, 1},
+		{"retryable errors", []error{&googleapi.Error{Code: 429}, &googleapi.Error{Code: 503}}, false, 3},
+		{"not retryable", []error{&googleapi.Error{Code: 403}}, true, 1},
+		{"retries exhausted", []error{&googleapi.Error{Code: 503}, &googleapi.Error{Code: 503}, &googleapi.Error{Code: 503}}, true, 3},
+	}
+	for _, test := range tests {
+		c := &flakyCall{errs: test.errs}
+		err := WithRetry(context.Background(), opts, "testing", c.call)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error %v, want error %v", test.name, err, test.wantErr)
+		}
+		if c.attempts != test.attempts {
+			t.Errorf("%s: %d attempts, want %d", test.name, c.attempts, test.attempts)
+		}
+	}
+}
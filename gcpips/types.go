@@ -0,0 +1,82 @@
+// Package gcpips lists the IP addresses used or reserved in the subnets of a shared VPC
+//
+// Inventory covers the common case of a single host project. The steps it is
+// made of (GetServiceProjects, GetAllResources, ExtractFields) are exported as
+// well, for callers that need more control, e.g. over which projects are
+// scanned or what happens to each project's lists as they are fetched.
+package gcpips
+
+import (
+	"google.golang.org/api/compute/v1"
+)
+
+// ProjectResources holds the lists of addresses and instances for a particular project
+// AddressList and InstanceList are the raw responses from GCP from calling
+// service.Addresses.AggregatedList(project) and
+// service.Instances.AggregatedList(project) respectively, with all pages merged (see ComputeClient)
+// ForwardingRuleList is the same for service.ForwardingRules.AggregatedList(project)
+type ProjectResources struct {
+	Project            string
+	AddressList        *compute.AddressAggregatedList
+	InstanceList       *compute.InstanceAggregatedList
+	ForwardingRuleList *compute.ForwardingRuleAggregatedList
+	Errors             []string `json:",omitempty"` // errors getting the lists above
+}
+
+// Complete tells whether all lists of the project were fetched successfully
+// Projects with errors are not recorded in the state file, so a resumed run fetches them again
+func (p *ProjectResources) Complete() bool {
+	return len(p.Errors) == 0
+}
+
+// AddressInfo holds the fields that we care about in our output table
+// The JSON field names are part of the JSON output format, and all fields are always present
+// (empty when unknown) so that consumers see the same schema for every address
+type AddressInfo struct {
+	Project string `json:"project"`
+	IP      string `json:"ip"`
+	Status  string `json:"status"`
+	Subnet  string `json:"subnet"` // see SubnetKey
+	User    string `json:"user"`
+	Label   string `json:"label"` // value of the label selected with Options.LabelColumn
+	Type    string `json:"type"`  // INTERNAL, EXTERNAL, ILB VIP or ALIAS (an alias IP range of an instance)
+	Zone    string `json:"zone"`  // zone of the instance, for instance IPs
+	// VPC network of the subnet (or of an external IP's instance or forwarding rule)
+	Network string `json:"network"`
+	// purpose of a reserved address, e.g. GCE_ENDPOINT or VPC_PEERING for a private services access range
+	Purpose string `json:"purpose"`
+	// description of a reserved address, free text given when it was reserved
+	Description string `json:"description"`
+	// region or zone the address, instance or forwarding rule is in ("global" for global ones)
+	Location string `json:"location"`
+
+	// whether the BYOIP prefix of an external IP is announced ("yes"/"no"), blank for other IPs
+	Announced string `json:"announced"`
+	// sole-tenant node ("node-group/node") the instance runs on
+	Node string `json:"node"`
+	// value of the instance metadata key selected with Options.MetadataColumn
+	Metadata string `json:"metadata"`
+	// name of the access config (e.g. "External NAT") an instance's external IP comes from
+	AccessConfig string `json:"access_config"`
+	// secondary range of the subnet an alias IP range is from, blank for the primary range
+	RangeName string `json:"range_name"`
+	// number of Project, with -include-project-number
+	ProjectNumber string `json:"project_number"`
+	// creation time of the instance (RFC 3339), for instance IPs
+	Created string `json:"created"`
+	// creation time of the reserved address (RFC 3339), for reserved addresses
+	Reserved string `json:"reserved"`
+	// static addresses that can still be reserved in the region of an external address, with -quota
+	Headroom string `json:"headroom"`
+
+	// self-link of the subnet
+	SubnetLink string `json:"-"`
+	// self-links of the users, for reserved addresses
+	UserLinks []string `json:"-"`
+	// region of a regional reserved address or forwarding rule
+	Region string `json:"-"`
+	// project of the subnet of an internal IP, see AddressKey
+	VPC string `json:"-"`
+	// entries for the same IP claimed by another resource (a different user or subnet)
+	Conflicts []*AddressInfo `json:"-"`
+}
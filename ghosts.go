@@ -0,0 +1,108 @@
+// Check of the resources that in-use addresses claim to be used by
+//
+// Occasionally an address stays IN_USE after the resource using it was
+// deleted, from eventual consistency or orphaned state. Such reservations
+// don't free up by themselves and need cleaning up by hand.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Report of the in-use addresses whose user doesn't exist
+const ghostsReportFile = "ghosts.md"
+
+// URL to look a resource up at, the self-link on endpoint (the Compute API base URL of -endpoint),
+// or the self-link itself without one
+func resourceURL(selfLink string, endpoint string) string {
+	i := strings.Index(selfLink, "projects/")
+	if endpoint == "" || i < 0 {
+		return selfLink
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/" + selfLink[i:]
+}
+
+// Whether the resource at selfLink exists
+func resourceExists(ctx context.Context, client *http.Client, selfLink string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, selfLink, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("%s: %s", selfLink, resp.Status)
+	}
+	return true, nil
+}
+
+// Write a report of the IN_USE reserved addresses with a user resource that doesn't resolve
+// Users that couldn't be checked are returned as errors, and not reported
+// Nothing is written when there is no ghost, and the report of a previous run is removed
+// Users are looked up on endpoint, see resourceURL
+// IPs are masked with mask, in the report as well as in the errors
+func checkGhosts(ctx context.Context, client *http.Client, endpoint string, addressesBySubnet map[string][]*AddressInfo, columns []column, sink outputSink, mask func(ip string) string) []string {
+	var ghosts []*AddressInfo
+	var errs []string
+	// ranges expanded with -expand-ranges, and addresses shared by load balancers, have the same users,
+	// which are only looked up once, and only reported once when the lookup fails
+	type result struct {
+		exists bool
+		err    error
+	}
+	checked := make(map[string]result)
+
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Status != "IN_USE" {
+				continue
+			}
+			// a ghost when any of its users is gone
+			for _, userLink := range addressInfo.UserLinks {
+				r, ok := checked[userLink]
+				if !ok {
+					r.exists, r.err = resourceExists(ctx, client, resourceURL(userLink, endpoint))
+					checked[userLink] = r
+					if r.err != nil {
+						logger.Error("Error checking user", "ip", mask(addressInfo.IP), "project", addressInfo.Project, "error", r.err)
+						errs = append(errs, fmt.Sprintf("%s: error checking user: %s", mask(addressInfo.IP), r.err))
+					}
+				}
+				if r.err == nil && !r.exists {
+					ghosts = append(ghosts, addressInfo)
+					break
+				}
+			}
+		}
+	}
+
+	if len(ghosts) == 0 {
+		if err := removeReport(sink, ghostsReportFile); err != nil {
+			logger.Error("Error removing report", "report", ghostsReportFile, "error", err)
+			errs = append(errs, fmt.Sprintf("%s: error removing report: %s", ghostsReportFile, err))
+		}
+		return errs
+	}
+
+	logger.Warn("Addresses are used by resources that don't exist", "addresses", len(ghosts), "report", ghostsReportFile)
+	if err := writeReport(sink, ghostsReportFile, "Addresses in use by resources that don't exist", columns, ghosts, mask); err != nil {
+		logger.Error("Error writing report", "report", ghostsReportFile, "error", err)
+		errs = append(errs, fmt.Sprintf("%s: error writing report: %s", ghostsReportFile, err))
+	}
+	return errs
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResourceURL(t *testing.T) {
+	selfLink := "https://www.googleapis.com/compute/v1/projects/svc/zones/us-central1-a/instances/vm-1"
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"", selfLink},
+		{"https://compute-psc.p.googleapis.com/compute/v1/", "https://compute-psc.p.googleapis.com/compute/v1/projects/svc/zones/us-central1-a/instances/vm-1"},
+		{"https://compute-psc.p.googleapis.com/compute/v1", "https://compute-psc.p.googleapis.com/compute/v1/projects/svc/zones/us-central1-a/instances/vm-1"},
+	}
+	for _, test := range tests {
+		if got := resourceURL(selfLink, test.endpoint); got != test.want {
+			t.Errorf("resourceURL(%q) = %q, want %q", test.endpoint, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Job summary for GitHub Actions
+//
+// Markdown appended to the file named by $GITHUB_STEP_SUMMARY is shown on the
+// job's page, see https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#adding-a-job-summary
+
+package main
+
+import (
+	"os"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Append the Markdown report of all subnets to a GitHub Actions job summary file
+// renderer is expected to render Markdown
+func appendGitHubSummary(path string, addressesBySubnet map[string][]*AddressInfo, subnets map[string]*compute.Subnetwork, renderer Renderer, opts *options) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := renderCombined(f, renderer, subnetGroups(addressesBySubnet, subnets, opts)); err != nil {
+		return err
+	}
+
+	logger.Progress("Appended report to GitHub job summary", "file", path)
+
+	return f.Close()
+}
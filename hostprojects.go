@@ -0,0 +1,39 @@
+// Scanning several shared VPCs in one run
+//
+// Subnets of different host projects can have the same name, and internal
+// IPs can be reused across VPCs, so with more than one host project subnets
+// are named after their host project as well (host-project__network__subnet), see
+// gcpips.SubnetKey and gcpips.AddressKey.
+
+package main
+
+import (
+	"strings"
+)
+
+// hostProjects is the value of the repeatable -host-project flag
+// Each use adds one or more (comma-separated) host projects
+type hostProjects []string
+
+func (h *hostProjects) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *hostProjects) Set(value string) error {
+	for _, project := range strings.Split(value, ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			*h = append(*h, project)
+		}
+	}
+	return nil
+}
+
+// Whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// HTML output, for publishing the inventory on a wiki or web server
+//
+// The pages are standalone (no external stylesheet or script). Values are
+// escaped by html/template, so resource names can't inject markup.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+th { background: #f0f0f0; }
+tr:nth-child(even) td { background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Subnets}}{{if $.Sections}}<h2>{{.Title}}</h2>
+{{end}}{{range .Summary}}<p>{{.}}</p>
+{{end}}<table>
+<thead>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{end}}</body>
+</html>
+`))
+
+// htmlPage is the data of htmlTemplate
+type htmlPage struct {
+	Title    string
+	Sections bool // whether each subnet has a heading of its own
+	Subnets  []*htmlSubnet
+}
+
+// htmlSubnet is a subnet of an htmlPage
+type htmlSubnet struct {
+	Title   string
+	Summary []string // lines about the subnet, above its table
+	Header  []string
+	Rows    [][]string
+}
+
+// htmlRenderer writes an HTML page with a table of addresses
+type htmlRenderer struct {
+	columns []column
+}
+
+func (r *htmlRenderer) Extension() string {
+	return "html"
+}
+
+func (r *htmlRenderer) Render(w io.Writer, group *subnetGroup) error {
+	subnet := r.subnet(group)
+	return htmlTemplate.Execute(w, &htmlPage{Title: subnet.Title, Subnets: []*htmlSubnet{subnet}})
+}
+
+// All subnets in one page, one section per subnet
+func (r *htmlRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	page := &htmlPage{Title: "IP inventory", Sections: true}
+	for _, group := range groups {
+		page.Subnets = append(page.Subnets, r.subnet(group))
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+// Template data of a subnet, with the same header lines as the Markdown output
+func (r *htmlRenderer) subnet(group *subnetGroup) *htmlSubnet {
+	subnet := &htmlSubnet{
+		Title:  "Reserved IPs for " + subnetTitle(group),
+		Header: tableHeader(r.columns),
+		Rows:   tableData(r.columns, group.Rows),
+	}
+	if group.Details != nil {
+		subnet.Summary = []string{
+			"Used: " + utilization(group),
+			fmt.Sprintf("Private Google access: %s, flow logs: %s",
+				enabled(group.Details.PrivateIpGoogleAccess), enabled(flowLogsEnabled(group.Details))),
+		}
+	}
+	return subnet
+}
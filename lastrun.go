@@ -0,0 +1,51 @@
+// Time of the last successful run, for listing only what is new since then
+//
+// The last run file holds a single RFC 3339 timestamp: the start of the last
+// run that finished without errors, so nothing created during a run is missed
+// by the next one.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default file the time of the last successful run is kept in
+const defaultLastRunFile = ".gcp-ips-last-run"
+
+// Read the time of the last successful run
+// A missing file means there was no such run, and gives the zero time
+func readLastRun(path string) (time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+// Record t as the time of the last successful run
+func writeLastRun(path string, t time.Time) error {
+	return ioutil.WriteFile(path, []byte(t.UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// Keep only the addresses of instances created after since
+// Addresses without a creation time (reservations no instance uses) are left out
+func createdSince(addressesBySubnet map[string][]*AddressInfo, since time.Time) map[string][]*AddressInfo {
+	filtered := make(map[string][]*AddressInfo)
+	for subnet, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			created, err := time.Parse(time.RFC3339, addressInfo.Created)
+			if err != nil || !created.After(since) {
+				continue
+			}
+			filtered[subnet] = append(filtered[subnet], addressInfo)
+		}
+	}
+	return filtered
+}
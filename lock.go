@@ -0,0 +1,67 @@
+// Lock file that keeps two runs from writing to the same output directory
+//
+// The lock is a file created exclusively in the output directory and removed
+// at the end of the run. A run that was killed leaves it behind; remove it by
+// hand once no other run is in progress
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the lock file in the output directory
+const lockFile = ".gcp-ips.lock"
+
+// How often a waiting run checks whether the lock was released
+const lockPollInterval = time.Second
+
+// runLock is a held lock file
+type runLock struct {
+	path string
+}
+
+// Take the lock file in dir, waiting up to wait for another run to release it
+// Fails with "another run in progress" when it is still held after wait (immediately when wait is 0)
+func acquireLock(dir string, wait time.Duration) (*runLock, error) {
+	path := filepath.Join(dir, lockFile)
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &runLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			pid, _ := ioutil.ReadFile(path)
+			return nil, fmt.Errorf("another run in progress (pid %s), lock file %s", strings.TrimSpace(string(pid)), path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Remove the lock file
+// Safe to call on a nil lock
+func (l *runLock) release() {
+	if l == nil {
+		return
+	}
+	if err := os.Remove(l.path); err != nil {
+		logger.Error("Error removing lock file", "file", l.path, "error", err)
+	}
+}
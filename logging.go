@@ -0,0 +1,43 @@
+// Logging of the command, in the format of -log-format
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+)
+
+// Logger of the command, at the level of -quiet and -verbose once the flags are parsed
+var logger = gcpips.NewLogger(&gcpips.Options{})
+
+// Set up logging for opts: human-readable text lines by default, or JSON lines with -log-format json
+// Lines from the library and from the standard log package go through the same handler
+func setupLogging(opts *options) error {
+	level := slog.LevelInfo
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	switch opts.LogFormat {
+	case "text":
+		// the default handler, which writes through the standard logger, with its timestamps
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("-log-format must be text or json")
+	}
+	logger = gcpips.NewLogger(&opts.Options)
+	return nil
+}
+
+// Exit status of fatal, exitCountFailure for the runtime failures of a run with -exit-count
+var fatalStatus = 1
+
+// Log msg and args as an error, and exit with fatalStatus
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(fatalStatus)
+}
@@ -1,5 +1,5 @@
 // Retrieves a list of IP addresses used by each subnet in a shared VPC
-// Formats results to Markdown tables and writes them to files
+// Formats results (Markdown tables by default) and writes them to files
 //
 // See https://godoc.org/google.golang.org/api/compute/v1 and
 // https://github.com/googleapis/google-api-go-client/tree/master/compute/v1/compute-gen.go
@@ -8,293 +8,1168 @@
 package main
 
 import (
-	"bytes"
-	"log"
-	"net"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	"github.com/olekukonko/tablewriter"
+	"github.com/sosimon/gcp-ips/gcpips"
 	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudasset/v1"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
 )
 
-// A struct to hold the lists of addresses and instances for a particular project
-// AddressList and InstanceList are the raw responses from GCP from calling
-// service.Addresses.AggregatedList(project).Do() and
-// service.Instances.AggregatedList(project).Do() respectively
-type projectResources struct {
-	Project      string
-	AddressList  *compute.AddressAggregatedList
-	InstanceList *compute.InstanceAggregatedList
-}
+// The scanning is done by the gcpips package, main only adds the command line around it
+type AddressInfo = gcpips.AddressInfo
+type projectResources = gcpips.ProjectResources
 
-// AddressInfo holds the fields that we care about in our output table
-type AddressInfo struct {
-	Project string
-	IP      string
-	Status  string
-	Subnet  string
-	User    string
+// subnetGroup holds the addresses of a subnet, together with the subnet itself
+type subnetGroup struct {
+	Name    string
+	Details *compute.Subnetwork // nil when the subnet couldn't be looked up
+	Range   string              // primary IP range (CIDR) of the subnet, "" when it couldn't be looked up
+	Used    int                 // listed addresses in the primary range, see usedIPs
+	Rows    []*AddressInfo
 }
 
-// Initialize the Compute API client
-func initClient() *compute.Service {
-	ctx := context.Background()
-
-	client, err := google.DefaultClient(ctx, compute.ComputeScope)
-	if err != nil {
-		log.Fatal(err)
-	}
+// options holds the settings given on the command line
+// The settings of the scan itself are in the embedded gcpips.Options
+type options struct {
+	gcpips.Options
 
-	computeService, err := compute.New(client)
-	if err != nil {
-		log.Fatal(err)
-	}
+	HostProjects       hostProjects
+	SingleProject      string
+	Credentials        string
+	Impersonate        string
+	Format             string
+	Output             string
+	SingleFile         bool
+	Stdout             bool
+	OutDir             string
+	FileNameTemplate   string
+	CompactJSON        bool
+	Lock               bool
+	DryRun             bool
+	LockWait           time.Duration
+	StateFile          string
+	Resume             bool
+	ChangedSince       string
+	AssetScope         string
+	Endpoint           string
+	Quota              bool
+	RenderTimeout      time.Duration
+	Timeout            time.Duration
+	MaskIPs            string
+	MaskSalt           string
+	IgnoreErrors       bool
+	ExitCount          string
+	MaxIdleConns       int
+	ProxyCert          string
+	ProxyKey           string
+	ProxyCA            string
+	CheckGhosts        bool
+	DNSZone            string
+	DNSProject         string
+	WriteEmptySubnets  bool
+	PostURL            string
+	PostToken          string
+	PostBasicAuth      string
+	PostOnly           bool
+	SortNaturalSubnets bool
+	Sort               string
+	LogFormat          string
+	Desc               bool
+	NewOnly            bool
+	SinceLastRun       bool
+	LastRunFile        string
+	Baseline           string
+	GitHubSummary      string
+	RetryOn            string
+	BYOIP              bool
+	TruncateNames      int
+	RenameColumns      string
+	Region             string
+	RegionsFile        string
+	SoleTenancy        bool
+	ProjectNumber      bool
+	Age                bool
 
-	return computeService
+	regions map[string]bool // regions to keep, from Region and RegionsFile, nil for all
+	// headers of the columns renamed with -rename-columns, by column name
+	renames map[string]string
+	// masks IPs according to -mask-ips, see ipMask
+	maskIP func(ip string) string
+	// names of the files of -filename-template
+	fileNames *fileNames
+	// projects that changed since -changed-since, nil for a full scan
+	changed map[string]bool
 }
 
-// Get a list of service projects for a given host project
-func getServiceProjects(hostProject string, service *compute.Service) (*compute.ProjectsGetXpnResources, error) {
-	log.Printf("Looking for service projects in %s\n", hostProject)
+// Build the HTTP transport shared by all API calls
+// The default transport only keeps 2 idle connections per host, which makes
+// the many concurrent calls to the same API host open new connections all the time
+// With -proxy-cert and -proxy-key, the client certificate is presented to an HTTPS_PROXY
+// that asks for one, and -proxy-ca is trusted in addition to the system CAs
+func newTransport(opts *options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConns
 
-	res, err := service.Projects.GetXpnResources(hostProject).Do()
+	if opts.ProxyCert == "" && opts.ProxyCA == "" {
+		return transport, nil
+	}
 
-	if err != nil {
-		log.Printf("Error getting service projects for %s: %s", hostProject, err)
+	tlsConfig := &tls.Config{}
+	if opts.ProxyCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ProxyCert, opts.ProxyKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if opts.ProxyCA != "" {
+		pem, err := ioutil.ReadFile(opts.ProxyCA)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", opts.ProxyCA)
+		}
+		tlsConfig.RootCAs = pool
 	}
+	// used for the TLS connection to the proxy as well as the one through it
+	transport.TLSClientConfig = tlsConfig
 
-	return res, err
+	return transport, nil
 }
 
-// Get the AddressAggregatedList and InstanceAggregatedList for a particular project
-func getResources(project string, service *compute.Service) *projectResources {
-	log.Printf("Looking for instances and IPs in %s\n", project)
+// Build the authenticated HTTP client shared by all API clients
+// Authenticates with the -credentials key file if given, the default credentials otherwise
+func newHTTPClient(opts *options, scopes ...string) (*http.Client, error) {
+	ctx := context.Background()
 
-	addressAggregatedList, err := service.Addresses.AggregatedList(project).Do()
+	// The caller's own credentials only need to mint tokens for the impersonated
+	// service account, which is granted the API scopes instead
+	apiScopes := scopes
+	if opts.Impersonate != "" {
+		scopes = []string{compute.CloudPlatformScope}
+	}
 
-	if err != nil {
-		log.Printf("Error getting reserved IPs for %s: %s", project, err)
+	var creds *google.Credentials
+	var err error
+	if opts.Credentials != "" {
+		// a service account key file, instead of the default credentials
+		data, readErr := ioutil.ReadFile(opts.Credentials)
+		if readErr != nil {
+			return nil, fmt.Errorf("error reading -credentials: %s", readErr)
+		}
+		creds, err = google.CredentialsFromJSONWithTypeAndParams(ctx, data, google.ServiceAccount, google.CredentialsParams{Scopes: scopes})
+		if err != nil {
+			return nil, fmt.Errorf("invalid -credentials %s: %s", opts.Credentials, err)
+		}
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, scopes...)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	instanceAggregatedList, err := service.Instances.AggregatedList(project).Do()
-	if err != nil {
-		log.Printf("Error getting instances for %s: %s", project, err)
+	source := creds.TokenSource
+	if opts.Impersonate != "" {
+		source, err = impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: opts.Impersonate,
+			Scopes:          apiScopes,
+		}, option.WithTokenSource(creds.TokenSource))
+		if err != nil {
+			return nil, fmt.Errorf("error impersonating %s: %s", opts.Impersonate, err)
+		}
 	}
 
-	output := &projectResources{
-		Project:      project,
-		AddressList:  addressAggregatedList,
-		InstanceList: instanceAggregatedList,
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up the proxy TLS configuration: %s", err)
 	}
 
-	return output
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: source,
+			Base:   transport,
+		},
+	}, nil
 }
 
-// Call getResources on all service projects attached to host project (shared VPC)
-func getAllResources(hostProject string, service *compute.Service) []*projectResources {
-	ch := make(chan *projectResources)
-	var wg sync.WaitGroup
+// Initialize the Compute API client
+// Uses the global endpoint unless an endpoint is given
+func initClient(client *http.Client, opts *options) (*compute.Service, error) {
+	ctx := context.Background()
 
-	// get list of service projects
-	res, err := getServiceProjects(hostProject, service)
-	if err != nil {
-		log.Fatal(err)
+	clientOptions := []option.ClientOption{option.WithHTTPClient(client)}
+	if opts.Endpoint != "" {
+		clientOptions = append(clientOptions, option.WithEndpoint(opts.Endpoint))
 	}
 
-	// goroutine for each project to get list of reserved IPs
-	for _, resource := range res.Resources {
-		projectID := resource.Id
-		wg.Add(1)
-		go func(projectID string) {
-			defer wg.Done()
-			ch <- getResources(projectID, service)
-		}(projectID)
+	return compute.NewService(ctx, clientOptions...)
+}
+
+// Whether flow logs are enabled on a subnet, either through its log config or the legacy field
+func flowLogsEnabled(subnetwork *compute.Subnetwork) bool {
+	if subnetwork.LogConfig != nil {
+		return subnetwork.LogConfig.Enable
 	}
+	return subnetwork.EnableFlowLogs
+}
 
-	// wait for all goroutines to finish and close the channel
-	go func() {
-		wg.Wait()
-		close(ch)
-	}()
+// Call gcpips.GetAllResources on all service projects attached to a host project (shared VPC), or on -single-project
+// With a state file, completed projects are checkpointed as they come in and,
+// when resuming, projects completed by a previous run are not fetched again
+// With -changed-since, the unchanged projects are taken from the state file, which is then
+// replaced with them and the changed projects fetched again
+// Projects that fail are returned with their Errors; an error is only returned
+// when the state file can't be used
+func getAllResources(ctx context.Context, projectIDs []string, client gcpips.ComputeClient, opts *options) ([]*projectResources, error) {
+	var err error
 
-	// gather all responses in output[]
-	var output []*projectResources
-	for s := range ch {
-		if s != nil {
-			output = append(output, s)
+	// load projects completed by a previous run
+	completed := make(map[string]*projectResources)
+	if opts.Resume {
+		completed, err = loadState(opts.StateFile)
+		if err != nil {
+			return nil, err
 		}
+		logger.Progress("Resuming", "projects", len(completed), "file", opts.StateFile)
+	}
+	if opts.changed != nil {
+		completed, err = loadState(opts.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		// fetch changed projects again
+		for projectID := range opts.changed {
+			delete(completed, projectID)
+		}
+		logger.Progress("Taking unchanged projects from the state file", "projects", len(completed), "file", opts.StateFile)
 	}
 
-	return output
-}
+	var state *stateWriter
+	if opts.StateFile != "" && opts.changed == nil {
+		state, err = openState(opts.StateFile, opts.Resume)
+		if err != nil {
+			return nil, err
+		}
+		defer state.Close()
+	}
 
-// Append an AddressInfo object into a map keyed by IP address
-// Handle case where the entry already exists
-func insertAddressInfo(addressInfoMap map[string]*AddressInfo, addressInfo *AddressInfo) {
-	ip := addressInfo.IP
-	// If IP already exists in the map, merge the information together. Existing entries has precedence.
-	// If the new addressInfo struct has different values than the existing entry, it won't be captured.
-	// Bottom line: this should work ok assuming the Address and Instances resources don't have
-	// contradicting information. Mainly it's the subnet and user that could be different.
-	if existingInfo, ok := addressInfoMap[ip]; ok {
-		if existingInfo.Status == "" {
-			existingInfo.Status = addressInfo.Status
+	// duplicate projects are only taken once from the state file, and only fetched once by gcpips.GetAllResources
+	taken := make(map[string]*projectResources)
+	var fetch []string
+	for _, projectID := range projectIDs {
+		if p, ok := completed[projectID]; ok {
+			taken[projectID] = p
+			continue
 		}
-		if existingInfo.Subnet == "" {
-			existingInfo.Subnet = addressInfo.Subnet
+		fetch = append(fetch, projectID)
+	}
+	var output []*projectResources
+	for _, p := range taken {
+		output = append(output, p)
+	}
+
+	output = append(output, gcpips.GetAllResources(ctx, fetch, client, &opts.Options, func(p *projectResources) {
+		if state != nil && p.Complete() {
+			if err := state.record(p); err != nil {
+				logger.Error("Error writing to state file", "project", p.Project, "error", err)
+			}
 		}
-		if existingInfo.User == "" {
-			existingInfo.User = addressInfo.User
+	})...)
+
+	// in project order, with the ones from the state file among the fetched ones
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Project < output[j].Project
+	})
+
+	if opts.StateFile != "" && opts.changed != nil {
+		if err := replaceState(opts.StateFile, output); err != nil {
+			logger.Error("Error writing state file", "file", opts.StateFile, "error", err)
 		}
-	} else {
-		addressInfoMap[ip] = addressInfo
 	}
-}
 
-// Parse self-links to get just the resource name at the end
-func getName(selfLink string) string {
-	split := strings.Split(selfLink, "/")
-	return split[len(split)-1]
+	return output, nil
 }
 
-// Process a list of projectResources, where each projectResource includes a list of all
-// Address and Instance resources in the project.
-// Returns a map of AddressInfo objects, whose keys are IP addresses
-func flatten(projectResourceList []*projectResources) map[string]*AddressInfo {
-	addressInfoMap := make(map[string]*AddressInfo)
+// Collect the warnings the API returned instead of data for some scopes (e.g. an unreachable region)
+// so that a skipped scope can be told apart from an empty one
+func scopedListWarnings(projectResourceList []*projectResources) []string {
+	var warnings []string
+	add := func(project string, list string, scope string, code string, message string) {
+		if code != "" && code != gcpips.NoResultsWarning {
+			_, location := gcpips.ParseScope(scope)
+			warnings = append(warnings, fmt.Sprintf("%s: %s in %s skipped: %s %s", project, list, location, code, message))
+		}
+	}
+
 	for _, p := range projectResourceList {
-		if p.AddressList == nil {
-			log.Printf(p.Project + " has no reserved addresses")
-		} else {
-			for _, addressScopedList := range p.AddressList.Items {
-				if addressScopedList.Addresses != nil {
-					for _, address := range addressScopedList.Addresses {
-						// make sure user is not nil, which happens when reserved IP
-						// is RESERVED but not IN_USE
-						var user string
-						if address.Users != nil {
-							user = getName(address.Users[0])
-						}
-						insertAddressInfo(addressInfoMap, &AddressInfo{
-							Project: p.Project,
-							IP:      address.Address,
-							Status:  address.Status,
-							Subnet:  getName(address.Subnetwork),
-							User:    user,
-						})
-					}
+		if p.AddressList != nil {
+			for scope, addressScopedList := range p.AddressList.Items {
+				if w := addressScopedList.Warning; w != nil {
+					add(p.Project, "addresses", scope, w.Code, w.Message)
 				}
 			}
 		}
-		if p.InstanceList == nil {
-			log.Printf(p.Project + " has no instances")
-		} else {
-			for _, instanceScopedList := range p.InstanceList.Items {
-				if instanceScopedList.Instances != nil {
-					for _, instance := range instanceScopedList.Instances {
-						insertAddressInfo(addressInfoMap, &AddressInfo{
-							Project: p.Project,
-							IP:      instance.NetworkInterfaces[0].NetworkIP,
-							Subnet:  getName(instance.NetworkInterfaces[0].Subnetwork),
-							User:    instance.Name,
-						})
-					}
+		if p.InstanceList != nil {
+			for scope, instanceScopedList := range p.InstanceList.Items {
+				if w := instanceScopedList.Warning; w != nil {
+					add(p.Project, "instances", scope, w.Code, w.Message)
+				}
+			}
+		}
+		if p.ForwardingRuleList != nil {
+			for scope, forwardingRuleScopedList := range p.ForwardingRuleList.Items {
+				if w := forwardingRuleScopedList.Warning; w != nil {
+					add(p.Project, "forwarding rules", scope, w.Code, w.Message)
 				}
 			}
 		}
 	}
-	return addressInfoMap
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// When the IP was taken: the creation time of its reservation, or else of its instance
+// The zero time when neither is known
+func creationTime(addressInfo *AddressInfo) time.Time {
+	for _, timestamp := range []string{addressInfo.Reserved, addressInfo.Created} {
+		if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
 }
 
-// Process a list of projectResources and re-organize it by subnet
-func extractFields(projectResourceList []*projectResources) map[string][]*AddressInfo {
-	addressInfoBySubnet := make(map[string][]*AddressInfo)
-	addressInfoByIP := flatten(projectResourceList)
-	for _, addressInfo := range addressInfoByIP {
-		subnet := addressInfo.Subnet
-		addressInfoBySubnet[subnet] = append(addressInfoBySubnet[subnet], addressInfo)
+// Names of the subnets in addressesBySubnet, in the order they are listed
+func subnetNames(addressesBySubnet map[string][]*AddressInfo, opts *options) []string {
+	var subnets []string
+	for subnet := range addressesBySubnet {
+		subnets = append(subnets, subnet)
 	}
-	return addressInfoBySubnet
+	sortSubnets(subnets, opts.SortNaturalSubnets)
+	return subnets
 }
 
 // Given a particular subnet and its list of AddressInfo objects,
-// Sort by IP address and then format and write info to a Markdown table
-func writeToFile(subnet string, addressInfoList []*AddressInfo) {
-	var data [][]string
+// format and write info to a file of sink using the given renderer
+func writeToFile(ctx context.Context, sink outputSink, name string, group *subnetGroup, renderer Renderer) error {
+	return writeFile(ctx, sink, name, func(w io.Writer) error {
+		return renderer.Render(w, group)
+	})
+}
 
-	// Create file
-	f, err := os.Create(subnet + ".md")
-	defer f.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
+// Sort IPs in ascending order (properly), IPv4 before IPv6, see compareAddrs
+// Ranges sort by their network address
+// Rows with the same address (or unparseable ones) are ordered by IP, project and user,
+// so that the order never depends on the order rows were collected in
+func sortByIP(addressInfoList []*AddressInfo) {
+	sort.Slice(addressInfoList, func(i, j int) bool {
+		return lessByIP(addressInfoList[i], addressInfoList[j])
+	})
+}
 
-	// Write header
-	_, err = f.WriteString("# Reserved IPs for " + subnet + "\n")
-	if err != nil {
-		log.Fatal(err)
+// Whether x comes before y in IP order, see sortByIP
+func lessByIP(x *AddressInfo, y *AddressInfo) bool {
+	if c := compareAddrs(parseAddr(x.IP), parseAddr(y.IP)); c != 0 {
+		return c < 0
+	}
+	if x.IP != y.IP {
+		return x.IP < y.IP
 	}
+	if x.Project != y.Project {
+		return x.Project < y.Project
+	}
+	return x.User < y.User
+}
+
+// Values of the columns that -sort can order rows by, other than ip
+var sortColumns = map[string]func(*AddressInfo) string{
+	"user":    func(a *AddressInfo) string { return a.User },
+	"project": func(a *AddressInfo) string { return a.Project },
+	"status":  func(a *AddressInfo) string { return a.Status },
+	// in UTC with a fixed width, so that the lexical order is the time order
+	"created": func(a *AddressInfo) string {
+		created := creationTime(a)
+		if created.IsZero() {
+			return ""
+		}
+		return created.UTC().Format("2006-01-02T15:04:05.000000000")
+	},
+}
 
-	// Sort IPs in ascending order (properly)
+// Sort addresses by the -sort column: by IP (see sortByIP), or lexically by another column
+// Rows with the same value are in IP order, so that the order stays deterministic
+// desc reverses the whole order, ties included, so it is exactly the ascending one backwards
+func sortAddresses(addressInfoList []*AddressInfo, by string, desc bool) {
+	less := lessByIP
+	if value, ok := sortColumns[by]; ok {
+		less = func(x *AddressInfo, y *AddressInfo) bool {
+			if a, b := value(x), value(y); a != b {
+				return a < b
+			}
+			return lessByIP(x, y)
+		}
+	}
 	sort.Slice(addressInfoList, func(i, j int) bool {
-		a := net.ParseIP(addressInfoList[i].IP)
-		b := net.ParseIP(addressInfoList[j].IP)
-		return bytes.Compare(a, b) < 0
+		if desc {
+			return less(addressInfoList[j], addressInfoList[i])
+		}
+		return less(addressInfoList[i], addressInfoList[j])
 	})
+}
 
-	for _, addressInfo := range addressInfoList {
-		// Append data to be written to file
-		data = append(data, []string{
-			addressInfo.IP,
-			addressInfo.Project,
-			addressInfo.Status,
-			addressInfo.User,
-		})
+// Run write, giving up on it after timeout (no limit when timeout is 0)
+// write is passed a context that is done once it is given up on, so that an abandoned
+// write stops writing and leaves the previous file in place (see outputSink)
+func writeWithTimeout(timeout time.Duration, write func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return write(context.Background())
 	}
 
-	// Write data to file
-	table := tablewriter.NewWriter(f)
-	table.SetHeader([]string{"IP", "GCP Project", "Status", "User"})
-	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
-	table.SetCenterSeparator("|")
-	table.AppendBulk(data)
-	table.Render()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- write(ctx)
+	}()
 
-	log.Printf("Writing to " + subnet + ".md\n")
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("abandoned after %s", timeout)
+	}
+}
+
+// The subnets to write, in order, with their addresses sorted by the -sort column
+// With -write-empty-subnets, known subnets without any address are included
+// Addresses without a subnet are in the gcpips.UnassignedGroup subnet
+func subnetGroups(addressesBySubnet map[string][]*AddressInfo, subnets map[string]*compute.Subnetwork, opts *options) []*subnetGroup {
+	if opts.WriteEmptySubnets && opts.GroupBy == gcpips.GroupBySubnet {
+		withEmpty := make(map[string][]*AddressInfo)
+		for subnet := range subnets {
+			withEmpty[subnet] = nil
+		}
+		for subnet, addressInfoList := range addressesBySubnet {
+			withEmpty[subnet] = addressInfoList
+		}
+		addressesBySubnet = withEmpty
+	}
+
+	var groups []*subnetGroup
+	for _, subnet := range subnetNames(addressesBySubnet, opts) {
+		addressInfoList := addressesBySubnet[subnet]
+		sortAddresses(addressInfoList, opts.Sort, opts.Desc)
+		group := &subnetGroup{Name: subnet, Rows: addressInfoList}
+		// with -group-by project, a group is not a subnet, even if it has the name of one
+		if opts.GroupBy == gcpips.GroupBySubnet {
+			group.Details = subnets[subnet]
+		}
+		if group.Details != nil {
+			group.Range = group.Details.IpCidrRange
+		}
+		// before rendering, which may mask the IPs
+		group.Used = usedIPs(group)
+		groups = append(groups, group)
+	}
+	return groups
 }
 
-// Format and write all addresses to Markdown files
+// Format and write all addresses to out
 // Loop through addressBySubnet map,
 // call writeToFile for each subnet,
-// with each subnet in a different file
-func writeAll(addressesBySubnet map[string][]*AddressInfo) {
-	for subnet, addressInfoList := range addressesBySubnet {
-		if subnet != "" {
-			writeToFile(subnet, addressInfoList)
+// with each subnet in a different file,
+// or all of them in out's single file
+// With -write-empty-subnets, known subnets without any address get a header-only file
+// A subnet that fails to write (or times out) is logged and skipped
+// Returns the number of files written and the errors of the ones that weren't
+func writeAll(addressesBySubnet map[string][]*AddressInfo, subnets map[string]*compute.Subnetwork, renderer Renderer, out *output, opts *options) (int, []string) {
+	groups := subnetGroups(addressesBySubnet, subnets, opts)
+
+	if out.single != "" {
+		err := writeWithTimeout(opts.RenderTimeout, func(ctx context.Context) error {
+			return writeFile(ctx, out.sink, out.single, func(w io.Writer) error {
+				return renderCombined(w, renderer, groups)
+			})
+		})
+		if err != nil {
+			logger.Error("Error writing", "file", out.sink.Path(out.single), "error", err)
+			return 0, []string{fmt.Sprintf("%s: error writing output: %s", out.sink.Path(out.single), err)}
+		}
+		return 1, nil
+	}
+
+	var written int
+	var errs []string
+	// a template that gives several groups the same name would overwrite files
+	names := make(map[string]string)
+	for _, group := range groups {
+		group := group
+		name, err := opts.fileNames.name(group, renderer.Extension())
+		if err == nil && names[name] != "" {
+			err = fmt.Errorf("-filename-template gives it the same name as %s: %s", names[name], name)
+		}
+		if err != nil {
+			logger.Error("Error writing", "subnet", group.Name, "error", err)
+			errs = append(errs, fmt.Sprintf("%s: error writing output: %s", group.Name, err))
+			continue
+		}
+		names[name] = group.Name
+		err = writeWithTimeout(opts.RenderTimeout, func(ctx context.Context) error {
+			return writeToFile(ctx, out.sink, name, group, renderer)
+		})
+		if err != nil {
+			logger.Error("Error writing", "subnet", group.Name, "error", err)
+			errs = append(errs, fmt.Sprintf("%s: error writing output: %s", group.Name, err))
+			continue
+		}
+		written++
+	}
+	return written, errs
+}
+
+// Name of the environment variable that backs a flag, e.g. single-project -> GCPIPS_SINGLE_PROJECT
+func envName(flagName string) string {
+	return "GCPIPS_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Set any flag that wasn't given on the command line from its GCPIPS_* environment variable
+// Flags given on the command line always take precedence
+func applyEnvDefaults(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %s", value, envName(f.Name), setErr)
+			}
 		}
+	})
+
+	return err
+}
+
+// Flags whose values are secrets, and are redacted by -dump-config
+var secretFlags = map[string]bool{
+	"post-token":      true,
+	"post-basic-auth": true,
+}
+
+// Print the effective value of every flag (after environment fallbacks) as JSON
+func dumpConfig(fs *flag.FlagSet) error {
+	config := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "REDACTED"
+		}
+		config[f.Name] = value
+	})
+
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
 	}
+	_, err = fmt.Printf("%s\n", out)
+	return err
+}
+
+// Print the usage message, with a description of each flag
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage:\n  %[1]s [flags] <host-project>...\n  %[1]s [flags] -single-project <project>\n\n", os.Args[0])
+	fmt.Fprintf(out, "Lists the IPs in use or reserved in each subnet of a shared VPC, one file per subnet.\n")
+	fmt.Fprintf(out, "Every flag can also be set as an environment variable, e.g. %s for -single-project.\n\nFlags:\n", envName("single-project"))
+	flag.PrintDefaults()
+}
+
+// Report a command line error with the usage message, and exit with status 2
+// like the flag package does for unknown flags
+func usageError(message string) {
+	fmt.Fprintf(flag.CommandLine.Output(), "Error: %s\n\n", message)
+	flag.Usage()
+	os.Exit(2)
 }
 
 func main() {
 	start := time.Now()
 
-	if len(os.Args) < 2 {
-		log.Fatalln("Missing required parameter: host-project")
+	opts := &options{}
+	flag.Usage = usage
+	flag.Var(&opts.HostProjects, "host-project", "shared VPC host project whose subnets and service projects are scanned (or give it as an argument), repeat or comma-separate for several")
+	flag.StringVar(&opts.Credentials, "credentials", "", "service account key file (JSON) to authenticate with (default application default credentials)")
+	flag.StringVar(&opts.Impersonate, "impersonate", "", "service account email to impersonate; the caller needs roles/iam.serviceAccountTokenCreator on it")
+	flag.StringVar(&opts.SingleProject, "single-project", "", "scan only this project, skipping shared VPC service project discovery")
+	flag.BoolVar(&opts.IncludeIP6, "include-ip6", false, "also list the IPv6 addresses of dual-stack instances")
+	flag.BoolVar(&opts.IncludeAliases, "include-alias-ranges", false, "also list the alias IP ranges of instances (e.g. GKE pod ranges), with the secondary range they're from")
+	flag.StringVar(&opts.Format, "format", "markdown", "output format: markdown, csv, tsv, html, xlsx, json, sqlite or prometheus-textfile")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "scan everything, but only log the files that would be written and their number of addresses, without writing or sending anything")
+	flag.StringVar(&opts.GroupBy, "group-by", gcpips.GroupBySubnet, "what to write a file for: subnet, project (all IPs of the project, whatever their subnet) or network (all subnets of a VPC network)")
+	flag.BoolVar(&opts.Lock, "lock", false, "take a lock file in the output directory, so that concurrent runs don't mix their files")
+	flag.DurationVar(&opts.LockWait, "lock-wait", 0, "with -lock, wait this long for another run to finish, e.g. 10m (default exit right away)")
+	flag.BoolVar(&opts.CompactJSON, "compact-json", false, "write JSON on a single line instead of indented, for ingestion")
+	flag.BoolVar(&opts.SingleFile, "single-file", false, "write all subnets to a single inventory.<format> file, same as -output inventory.md for Markdown")
+	flag.BoolVar(&opts.Stdout, "stdout", false, "write all subnets to stdout, same as -output -")
+	flag.StringVar(&opts.FileNameTemplate, "filename-template", defaultFileNameTemplate, "name of the file of each subnet, a Go template with the fields {{.Subnet}}, {{.Network}}, {{.Project}}, {{.Date}} and {{.Ext}}")
+	flag.StringVar(&opts.OutDir, "out-dir", "", "write files to this directory, created if needed, same as -output <dir>/")
+	flag.StringVar(&opts.Output, "output", "", "where to write: a directory (one file per subnet), a file (all subnets in one file), - for stdout, or a gs://bucket/path (default current directory)")
+	flag.IntVar(&opts.BatchHostProjects, "batch-host-projects", 5, "number of host projects whose service projects are listed at the same time")
+	flag.IntVar(&opts.Concurrency, "concurrency", 10, "number of service projects fetched at the same time")
+	flag.IntVar(&opts.FlattenConcurrency, "flatten-concurrency", 1, "number of projects whose results are processed in parallel")
+	flag.StringVar(&opts.StateFile, "state-file", "", "record fetched projects in this file so an interrupted run can be resumed")
+	flag.StringVar(&opts.ChangedSince, "changed-since", "", "only fetch projects with address/instance changes since this time (RFC 3339) according to Cloud Asset Inventory, taking the others from -state-file")
+	flag.StringVar(&opts.AssetScope, "asset-scope", "", "organization or folder searched for changes with -changed-since, e.g. organizations/123")
+	flag.BoolVar(&opts.Resume, "resume", false, "skip projects already recorded in -state-file by a previous run")
+	flag.BoolVar(&opts.ExpandRanges, "expand-ranges", false, fmt.Sprintf("list reserved ranges of up to %d addresses one address per row, and larger ones as a CIDR", gcpips.MaxExpandedRange))
+	flag.BoolVar(&opts.BYOIP, "byoip", false, "add an Announced column telling whether the BYOIP prefix of external IPs is announced")
+	flag.StringVar(&opts.RenameColumns, "rename-columns", "", "comma-separated column=Header pairs overriding the column headers, e.g. ip=Address,user=Owner")
+	flag.IntVar(&opts.TruncateNames, "truncate-names", 0, "shorten user and project names longer than this in Markdown and HTML tables (other formats keep full names)")
+	flag.BoolVar(&opts.Age, "age", false, "add an Age column with the number of days since each IP was reserved (or its instance created)")
+	flag.BoolVar(&opts.ProjectNumber, "include-project-number", false, "add a Project Number column, to correlate with audit logs")
+	flag.BoolVar(&opts.SoleTenancy, "sole-tenancy", false, "add a column with the sole-tenant node each instance runs on")
+	flag.StringVar(&opts.LabelColumn, "label-column", "", "add a column with the value of this instance/address label, e.g. cost-center")
+	flag.StringVar(&opts.MetadataColumn, "metadata-column", "", "add a column with the value of this instance metadata key, e.g. owner")
+	flag.BoolVar(&opts.NewOnly, "new-only", false, "only list addresses that are not in the -baseline report")
+	flag.BoolVar(&opts.SinceLastRun, "since-last-run", false, "only list IPs of instances created since the last successful run")
+	flag.StringVar(&opts.LastRunFile, "last-run-file", defaultLastRunFile, "file the time of the last successful run is kept in")
+	flag.StringVar(&opts.Baseline, "baseline", "", "comma-separated JSON reports of a previous run, for -new-only")
+	flag.StringVar(&opts.Region, "region", "", "only list IPs in these comma-separated regions, e.g. us-central1,europe-west1 (zonal IPs match their region)")
+	flag.StringVar(&opts.RegionsFile, "regions-file", "", "only list IPs in the regions listed in this file, one per line")
+	flag.StringVar(&opts.Status, "status", "", "comma-separated statuses to list, e.g. RESERVED for reserved but unused IPs or IN_USE for used ones, which includes instance IPs (default all)")
+	flag.StringVar(&opts.ExcludeStatus, "exclude-status", "", "comma-separated statuses to leave out, e.g. IN_USE to only list reserved but unused IPs (instance IPs count as IN_USE)")
+	flag.StringVar(&opts.MaskIPs, "mask-ips", "", "mask IPs in the output: host (hide the host portion) or hash (salted hash of the IP)")
+	flag.StringVar(&opts.MaskSalt, "mask-salt", "", "salt for -mask-ips hash")
+	flag.StringVar(&opts.Sort, "sort", "ip", "column to order the rows of each subnet by: ip, user, project, status or created (ties are in IP order)")
+	flag.BoolVar(&opts.Desc, "desc", false, "reverse the order of -sort, e.g. highest IPs or newest first")
+	flag.BoolVar(&opts.SortNaturalSubnets, "sort-natural-subnets", false, "order subnets naturally (subnet-2 before subnet-10) instead of lexically")
+	flag.BoolVar(&opts.WriteEmptySubnets, "write-empty-subnets", false, "also write a (header-only) file for subnets without any IPs")
+	flag.DurationVar(&opts.RenderTimeout, "render-timeout", 0, "give up on writing a file after this long, e.g. 30s (default no limit)")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "give up on the API calls after this long, e.g. 15m, and exit without writing any output (default no limit)")
+	flag.BoolVar(&opts.Quota, "quota", false, "compare reserved external IPs against each region's static address quota")
+	flag.Int64Var(&opts.MaxPageSize, "max-page-size", 0, fmt.Sprintf("number of results per page of the list calls, 1-%d (default API default)", gcpips.MaxPageSizeLimit))
+	flag.BoolVar(&opts.Verbose, "verbose", false, "also log the status, subnet or user discarded when merging entries for the same IP, and how long each API call took")
+	flag.StringVar(&opts.LogFormat, "log-format", "text", "format of the log: text, or json for a JSON object per line")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "only log errors, warnings and the summary, not a line per project or file")
+	flag.BoolVar(&opts.IgnoreErrors, "ignore-errors", false, "exit zero despite errors (e.g. unreadable projects) as long as some output was written")
+	flag.StringVar(&opts.ExitCount, "exit-count", "", fmt.Sprintf("exit with the number of orphaned-external (reserved, unused external) addresses or rows, capped at %d, and %d on errors", maxExitCount, exitCountFailure))
+	flag.IntVar(&opts.MaxIdleConns, "max-idle-conns", 100, "number of idle API connections kept open for reuse")
+	flag.StringVar(&opts.ProxyCert, "proxy-cert", "", "client certificate (PEM) for an HTTPS_PROXY that requires one")
+	flag.StringVar(&opts.ProxyKey, "proxy-key", "", "private key (PEM) of -proxy-cert")
+	flag.StringVar(&opts.ProxyCA, "proxy-ca", "", "CA certificate (PEM) of the HTTPS_PROXY, trusted in addition to the system CAs")
+	flag.StringVar(&opts.PostURL, "post-url", "", "also POST all addresses as a JSON array to this URL")
+	flag.StringVar(&opts.PostToken, "post-token", "", "bearer token for -post-url")
+	flag.StringVar(&opts.PostBasicAuth, "post-basic-auth", "", "user:password for basic auth to -post-url")
+	flag.BoolVar(&opts.PostOnly, "post-only", false, "only POST to -post-url, don't write any files")
+	flag.StringVar(&opts.GitHubSummary, "github-summary", os.Getenv("GITHUB_STEP_SUMMARY"), "append a Markdown report to this GitHub Actions job summary file (default $GITHUB_STEP_SUMMARY)")
+	flag.BoolVar(&opts.CheckGhosts, "check-ghosts", false, "check that the users of IN_USE addresses exist, and list the ones that don't in "+ghostsReportFile)
+	flag.StringVar(&opts.DNSZone, "dns-zone", "", "list external IPs without an A/AAAA record in this Cloud DNS managed zone")
+	flag.StringVar(&opts.DNSProject, "dns-project", "", "project of -dns-zone (default first host project)")
+	flag.StringVar(&opts.RetryOn, "retry-on", gcpips.DefaultRetryOn, "comma-separated HTTP status codes of API errors to retry")
+	flag.IntVar(&opts.MaxRetries, "max-retries", gcpips.DefaultMaxRetries, "number of times an API call failing with a -retry-on status is retried, with exponential backoff")
+	flag.StringVar(&opts.Endpoint, "endpoint", "", "Compute API base URL, e.g. a regional Private Service Connect endpoint (default global endpoint)")
+	dumpConfigFlag := flag.Bool("dump-config", false, "print the effective settings (flags and environment) as JSON and exit")
+	flag.Parse()
+
+	// arguments are host projects too, and win over -host-project from the environment
+	for _, arg := range flag.Args() {
+		flag.Set("host-project", arg)
+	}
+
+	if err := applyEnvDefaults(flag.CommandLine); err != nil {
+		usageError(err.Error())
+	}
+
+	if opts.Quiet && opts.Verbose {
+		usageError("-quiet and -verbose can't be used together")
+	}
+	if err := setupLogging(opts); err != nil {
+		usageError(err.Error())
+	}
+
+	if *dumpConfigFlag {
+		if err := dumpConfig(flag.CommandLine); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+
+	if opts.SingleProject == "" && len(opts.HostProjects) == 0 {
+		usageError("missing required parameter: host-project")
+	}
+	if opts.SingleProject != "" && len(opts.HostProjects) > 0 {
+		usageError("-single-project and -host-project can't be combined")
 	}
 
-	hostProject := os.Args[1]
+	if opts.Credentials != "" {
+		if _, err := os.Stat(opts.Credentials); err != nil {
+			fatal("Invalid -credentials", "error", err)
+		}
+	}
 
-	computeService := initClient()
-	resources := getAllResources(hostProject, computeService)
-	addressInfoBySubnet := extractFields(resources)
-	writeAll(addressInfoBySubnet)
+	if opts.MaxPageSize < 0 || opts.MaxPageSize > gcpips.MaxPageSizeLimit {
+		fatal(fmt.Sprintf("-max-page-size must be between 1 and %d", gcpips.MaxPageSizeLimit))
+	}
+
+	retryCodes, err := gcpips.ParseStatusCodes(opts.RetryOn)
+	if err != nil {
+		fatal("Invalid -retry-on", "error", err)
+	}
+	opts.RetryCodes = retryCodes
+
+	if opts.MaxRetries < 0 {
+		fatal("-max-retries must not be negative")
+	}
+
+	if opts.Region != "" || opts.RegionsFile != "" {
+		regions := splitRegions(opts.Region)
+		if opts.RegionsFile != "" {
+			fileRegions, err := readRegionsFile(opts.RegionsFile)
+			if err != nil {
+				fatal("Error reading regions file", "error", err)
+			}
+			regions = append(regions, fileRegions...)
+		}
+		if len(regions) == 0 {
+			fatal("-region and -regions-file don't list any region")
+		}
+		opts.regions = make(map[string]bool)
+		for _, region := range regions {
+			opts.regions[region] = true
+		}
+	}
+
+	switch opts.GroupBy {
+	case gcpips.GroupBySubnet, gcpips.GroupByProject, gcpips.GroupByNetwork:
+	default:
+		fatal(fmt.Sprintf("-group-by must be %s, %s or %s", gcpips.GroupBySubnet, gcpips.GroupByProject, gcpips.GroupByNetwork))
+	}
+	if opts.GroupBy != gcpips.GroupBySubnet && opts.Format == "prometheus-textfile" {
+		fatal("-format prometheus-textfile has gauges by subnet, and requires -group-by subnet")
+	}
+	if _, ok := sortColumns[opts.Sort]; !ok && opts.Sort != "ip" {
+		fatal("-sort must be ip, user, project, status or created")
+	}
+
+	if opts.BatchHostProjects < 1 {
+		fatal("-batch-host-projects must be at least 1")
+	}
+
+	if opts.Concurrency < 1 {
+		fatal("-concurrency must be at least 1")
+	}
+
+	if opts.FlattenConcurrency < 1 {
+		fatal("-flatten-concurrency must be at least 1")
+	}
+
+	if (opts.ProxyCert == "") != (opts.ProxyKey == "") {
+		fatal("-proxy-cert and -proxy-key go together")
+	}
+
+	if opts.PostOnly && opts.PostURL == "" {
+		fatal("-post-only requires -post-url")
+	}
+	if opts.PostToken != "" && opts.PostBasicAuth != "" {
+		usageError("-post-token and -post-basic-auth can't be combined")
+	}
+
+	var baseline map[string]bool
+	if opts.NewOnly {
+		if opts.Baseline == "" {
+			fatal("-new-only requires -baseline")
+		}
+		baseline, err = loadBaseline(opts.Baseline)
+		if err != nil {
+			fatal("Error loading baseline", "error", err)
+		}
+	}
+
+	if opts.ExitCount != "" {
+		if err := validateExitCount(opts.ExitCount); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if opts.LockWait != 0 && !opts.Lock {
+		fatal("-lock-wait requires -lock")
+	}
+
+	var lastRun time.Time
+	if opts.SinceLastRun {
+		lastRun, err = readLastRun(opts.LastRunFile)
+		if err != nil {
+			fatal("Error reading last run file", "error", err)
+		}
+	}
+
+	if opts.Resume && opts.StateFile == "" {
+		fatal("-resume requires -state-file")
+	}
+
+	var changedSince time.Time
+	if opts.ChangedSince != "" {
+		if opts.StateFile == "" || opts.AssetScope == "" {
+			fatal("-changed-since requires -state-file and -asset-scope")
+		}
+		changedSince, err = time.Parse(time.RFC3339, opts.ChangedSince)
+		if err != nil {
+			fatal("Invalid -changed-since", "error", err)
+		}
+	}
+
+	opts.fileNames, err = parseFileNames(opts.FileNameTemplate, start)
+	if err != nil {
+		fatal("Invalid -filename-template", "error", err)
+	}
+
+	renames, err := parseRenames(opts.RenameColumns)
+	if err != nil {
+		fatal("Invalid -rename-columns", "error", err)
+	}
+	if err := validateRenames(renames, tableColumns(opts)); err != nil {
+		fatal("Invalid -rename-columns", "error", err)
+	}
+	opts.renames = renames
+
+	renderer, err := newRenderer(opts.Format, tableColumns(opts), opts.TruncateNames, opts.CompactJSON)
+	if err != nil {
+		fatal(err.Error())
+	}
+	opts.maskIP, err = ipMask(opts.MaskIPs, opts.MaskSalt)
+	if err != nil {
+		fatal(err.Error())
+	}
+	masked, _ := newMaskingRenderer(renderer, opts.MaskIPs, opts.MaskSalt)
+	// gauges don't show IPs, and count them by subnet range, which needs them unmasked
+	if _, ok := renderer.(*promRenderer); !ok {
+		renderer = masked
+	}
+
+	opts.Output, err = outputTarget(opts, renderer.Extension())
+	if err != nil {
+		usageError(err.Error())
+	}
+	if opts.Output == "-" && opts.RenderTimeout > 0 {
+		usageError("-render-timeout can't be used with stdout")
+	}
+
+	// the flags are valid, so from here on a failure must not read as a count
+	if opts.ExitCount != "" {
+		fatalStatus = exitCountFailure
+	}
+
+	scopes := []string{compute.ComputeScope}
+	if opts.DNSZone != "" {
+		scopes = append(scopes, dns.NdevClouddnsReadonlyScope)
+	}
+	if isGCS(opts.Output) {
+		scopes = append(scopes, storage.DevstorageReadWriteScope)
+	}
+	if opts.ChangedSince != "" {
+		scopes = append(scopes, cloudasset.CloudPlatformScope)
+	}
+	// API calls stop on Ctrl+C (or SIGTERM) and after -timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	client, err := newHTTPClient(opts, scopes...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	computeService, err := initClient(client, opts)
+	if err != nil {
+		fatal(err.Error())
+	}
+	computeClient := gcpips.NewComputeClient(computeService)
+
+	out, err := parseOutput(opts.Output, client, opts.DryRun)
+	if err != nil {
+		fatal("Invalid -output", "error", err)
+	}
+	// reports go next to the per-subnet files, or the single file
+	// (the current directory when writing to stdout)
+	reports := out.sink
+	if out.single == "-" {
+		reports = dirSink(".")
+	}
+
+	var lock *runLock
+	if opts.Lock && !opts.DryRun {
+		dir, ok := reports.(dirSink)
+		if !ok {
+			fatal("-lock requires a local -output")
+		}
+		lock, err = acquireLock(string(dir), opts.LockWait)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	// projects holding the subnets
+	subnetProjects := []string(opts.HostProjects)
+	if opts.SingleProject != "" {
+		subnetProjects = []string{opts.SingleProject}
+	}
+	opts.QualifySubnets = len(subnetProjects) > 1
+	// errors that didn't stop the run
+	var failures []string
+
+	subnets := make(map[string]*compute.Subnetwork)
+	for _, subnetProject := range subnetProjects {
+		projectSubnets, err := gcpips.GetSubnets(ctx, subnetProject, computeClient, &opts.Options)
+		if err != nil {
+			logger.Error("Error getting subnets, subnet details will be missing", "project", subnetProject, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: error getting subnets: %s", subnetProject, err))
+		}
+		for name, subnet := range projectSubnets {
+			subnets[name] = subnet
+		}
+	}
+
+	if opts.ChangedSince != "" {
+		changed, err := getChangedProjects(ctx, client, opts.AssetScope, changedSince)
+		if err != nil {
+			logger.Error("Error searching for changed projects, fetching all projects", "scope", opts.AssetScope, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: error searching for changed projects: %s", opts.AssetScope, err))
+		} else {
+			logger.Progress("Projects changed", "projects", len(changed), "since", opts.ChangedSince)
+			opts.changed = changed
+		}
+	}
+
+	var projectIDs []string
+	if opts.SingleProject != "" {
+		// Spot check of a single project: no shared VPC enumeration needed
+		projectIDs = []string{opts.SingleProject}
+	} else {
+		serviceProjects, errs := gcpips.GetAllServiceProjects(ctx, opts.HostProjects, computeClient, &opts.Options)
+		failures = append(failures, errs...)
+		for _, hostProject := range opts.HostProjects {
+			projectIDs = append(projectIDs, serviceProjects[hostProject]...)
+		}
+	}
+	// the state file applies to a single project as well
+	resources, err := getAllResources(ctx, projectIDs, computeClient, opts)
+	if err != nil {
+		lock.release()
+		fatal("Error using state file", "file", opts.StateFile, "error", err)
+	}
+
+	failedProjects := 0
+	for _, p := range resources {
+		for _, e := range p.Errors {
+			failures = append(failures, p.Project+": "+e)
+		}
+		if !p.Complete() {
+			failedProjects++
+		}
+	}
+
+	if opts.regions != nil {
+		filterRegions(resources, opts.regions)
+		filterSubnetRegions(subnets, opts.regions)
+	}
+
+	warnings := scopedListWarnings(resources)
+	for _, w := range warnings {
+		logger.Warn(w)
+	}
+	if len(warnings) > 0 {
+		logger.Warn("Regions/zones were skipped by the API, their addresses are missing", "scopes", len(warnings))
+	}
+
+	addressInfoBySubnet := gcpips.ExtractFields(resources, subnets, &opts.Options)
+	if opts.NewOnly {
+		addressInfoBySubnet = newOnly(addressInfoBySubnet, baseline)
+	}
+	if opts.SinceLastRun {
+		if lastRun.IsZero() {
+			logger.Progress("No previous run recorded, listing all IPs", "file", opts.LastRunFile)
+		} else {
+			logger.Progress("Listing IPs of instances created since the last run", "since", lastRun.Format(time.RFC3339))
+			addressInfoBySubnet = createdSince(addressInfoBySubnet, lastRun)
+		}
+	}
+
+	if opts.BYOIP {
+		// prefixes are usually in the host project, but can be in any of the scanned projects
+		prefixProjects := append([]string{}, subnetProjects...)
+		for _, p := range resources {
+			if !contains(subnetProjects, p.Project) {
+				prefixProjects = append(prefixProjects, p.Project)
+			}
+		}
+		prefixes, errs := getPublicDelegatedPrefixes(ctx, prefixProjects, computeService, opts)
+		failures = append(failures, errs...)
+		annotateAnnounced(addressInfoBySubnet, prefixes)
+	}
+
+	if opts.SoleTenancy {
+		nodes, errs := getSoleTenantNodes(ctx, resources, computeService, opts)
+		failures = append(failures, errs...)
+		annotateNodes(addressInfoBySubnet, nodes)
+	}
+
+	if opts.ProjectNumber {
+		numbers, errs := getProjectNumbers(ctx, resources, computeService, opts)
+		failures = append(failures, errs...)
+		annotateProjectNumbers(addressInfoBySubnet, numbers)
+	}
+
+	if opts.Quota {
+		quotas, errs := getQuotas(ctx, resources, computeService, opts)
+		failures = append(failures, errs...)
+		logQuotas(quotas)
+		annotateHeadroom(addressInfoBySubnet, quotas)
+	}
+
+	// whatever was fetched is incomplete, don't overwrite the previous output with it
+	if err := ctx.Err(); err != nil {
+		lock.release()
+		fatal("Stopped before writing any output", "error", err)
+	}
+
+	logSummary(addressInfoBySubnet, len(resources))
+
+	var written int
+	switch {
+	case opts.DryRun:
+		written = dryRun(addressInfoBySubnet, subnets, renderer, out, opts)
+	case !opts.PostOnly:
+		var writeErrors []string
+		written, writeErrors = writeAll(addressInfoBySubnet, subnets, renderer, out, opts)
+		failures = append(failures, writeErrors...)
+	}
+
+	// a dry run doesn't write reports, or send anything
+	if !opts.DryRun {
+		if opts.GitHubSummary != "" {
+			// the mask was already validated with the file renderer
+			summaryRenderer, _ := newMaskingRenderer(&markdownRenderer{columns: truncateNames(tableColumns(opts), opts.TruncateNames), heading: "#"}, opts.MaskIPs, opts.MaskSalt)
+			if err := appendGitHubSummary(opts.GitHubSummary, addressInfoBySubnet, subnets, summaryRenderer, opts); err != nil {
+				logger.Error("Error writing GitHub job summary", "error", err)
+				failures = append(failures, fmt.Sprintf("%s: error writing GitHub job summary: %s", opts.GitHubSummary, err))
+			}
+		}
+
+		if opts.PostURL != "" {
+			if err := postResults(ctx, opts.PostURL, addressInfoBySubnet, opts); err != nil {
+				logger.Error("Error posting results", "error", err)
+				failures = append(failures, fmt.Sprintf("%s: error posting results: %s", opts.PostURL, err))
+			} else {
+				written++
+			}
+		}
+
+		if err := writeStuckReport(reports, addressInfoBySubnet, tableColumns(opts), opts.maskIP); err != nil {
+			logger.Error("Error writing report", "report", stuckReportFile, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: error writing report: %s", stuckReportFile, err))
+		}
+
+		if err := writeConflictsReport(reports, addressInfoBySubnet, tableColumns(opts), opts.maskIP); err != nil {
+			logger.Error("Error writing report", "report", conflictsReportFile, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: error writing report: %s", conflictsReportFile, err))
+		}
+
+		if opts.CheckGhosts {
+			failures = append(failures, checkGhosts(ctx, client, opts.Endpoint, addressInfoBySubnet, tableColumns(opts), reports, opts.maskIP)...)
+		}
+
+		if opts.DNSZone != "" {
+			dnsProject := opts.DNSProject
+			if dnsProject == "" {
+				dnsProject = subnetProjects[0]
+			}
+			if err := checkDNS(ctx, client, dnsProject, opts.DNSZone, addressInfoBySubnet, tableColumns(opts), reports, opts.maskIP); err != nil {
+				logger.Error("Error checking DNS records", "zone", opts.DNSZone, "error", err)
+				failures = append(failures, fmt.Sprintf("%s: error checking DNS records: %s", opts.DNSZone, err))
+			}
+		}
+	}
+
+	exitCode := 0
+	if failedProjects > 0 {
+		logger.Error("Projects failed, their addresses are missing or incomplete", "failed", failedProjects, "projects", len(resources))
+	}
+	if len(failures) > 0 {
+		if opts.DryRun {
+			logger.Error("Errors during the run", "errors", len(failures))
+			for _, failure := range failures {
+				logger.Error(failure)
+			}
+		} else {
+			logger.Error("Errors during the run", "errors", len(failures), "report", errorSummaryFile)
+			if err := writeErrorSummary(reports, errorSummaryFile, failures); err != nil {
+				logger.Error("Error writing report", "report", errorSummaryFile, "error", err)
+			}
+		}
+		// with -ignore-errors, a run that produced output is a success
+		if !opts.IgnoreErrors || written == 0 {
+			exitCode = 1
+		}
+	} else if !opts.DryRun {
+		if err := removeReport(reports, errorSummaryFile); err != nil {
+			logger.Error("Error removing report", "report", errorSummaryFile, "error", err)
+		}
+	}
+	if opts.ExitCount != "" {
+		if exitCode != 0 {
+			exitCode = exitCountFailure
+		} else {
+			exitCode = exitCount(opts.ExitCount, addressInfoBySubnet)
+			logger.Info("Exit status", "status", exitCode, "exit_count", opts.ExitCount)
+		}
+	}
+
+	if opts.SinceLastRun && len(failures) == 0 && !opts.DryRun {
+		if err := writeLastRun(opts.LastRunFile, start); err != nil {
+			logger.Error("Error writing last run file", "file", opts.LastRunFile, "error", err)
+		}
+	}
+
+	lock.release()
 
 	elapsed := time.Since(start)
-	log.Printf("Took %.2f seconds", elapsed.Seconds())
+	logger.Info("Done", "duration", elapsed.Round(10*time.Millisecond))
+
+	os.Exit(exitCode)
 }
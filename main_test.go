@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"google.golang.org/api/compute/v1"
+)
+
+// Resources of a few projects, with IPs claimed by several of them so that entries are merged,
+// and the projects, scopes and instances in a random order
+func shuffledResources(r *rand.Rand) []*projectResources {
+	subnet := "https://www.googleapis.com/compute/v1/projects/host/regions/us-central1/subnetworks/s1"
+	network := "https://www.googleapis.com/compute/v1/projects/host/global/networks/vpc1"
+	var resources []*projectResources
+	for p := 0; p < 4; p++ {
+		project := fmt.Sprintf("svc-%d", p)
+		instances := make(map[string]compute.InstancesScopedList)
+		for z, zone := range []string{"zones/us-central1-a", "zones/us-central1-b"} {
+			var list []*compute.Instance
+			for i := 0; i < 5; i++ {
+				list = append(list, &compute.Instance{
+					Name: fmt.Sprintf("vm-%d-%d-%d", p, z, i),
+					NetworkInterfaces: []*compute.NetworkInterface{{
+						// the IPs of the last two projects are also claimed by the first two
+						NetworkIP:  fmt.Sprintf("10.0.%d.%d", p%2, z*10+i),
+						Subnetwork: subnet,
+						Network:    network,
+					}},
+				})
+			}
+			r.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+			instances[zone] = compute.InstancesScopedList{Instances: list}
+		}
+		resources = append(resources, &projectResources{
+			Project:      project,
+			InstanceList: &compute.InstanceAggregatedList{Items: instances},
+		})
+	}
+	r.Shuffle(len(resources), func(i, j int) { resources[i], resources[j] = resources[j], resources[i] })
+	return resources
+}
+
+// Render the addresses of resources in format, as a single document
+func renderAll(t *testing.T, resources []*projectResources, format string, opts *options) []byte {
+	addressesBySubnet := gcpips.ExtractFields(resources, nil, &opts.Options)
+	renderer, err := newRenderer(format, tableColumns(opts), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := renderCombined(&buf, renderer, subnetGroups(addressesBySubnet, nil, opts)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOutputIsDeterministic(t *testing.T) {
+	for _, format := range []string{"markdown", "csv", "json"} {
+		for _, sort := range []string{"ip", "user", "status"} {
+			opts := &options{Format: format, Sort: sort}
+			opts.FlattenConcurrency = 4
+			want := renderAll(t, shuffledResources(rand.New(rand.NewSource(1))), format, opts)
+			if !bytes.Contains(want, []byte("10.0.1.14")) {
+				t.Fatalf("%s output is missing addresses:\n%s", format, want)
+			}
+			for seed := int64(2); seed < 5; seed++ {
+				got := renderAll(t, shuffledResources(rand.New(rand.NewSource(seed))), format, opts)
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s output sorted by %s differs with another input order:\n%s\nwant\n%s", format, sort, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Masking of IP addresses in rendered output, for reports shared outside the organization
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// maskingRenderer wraps a Renderer and masks the IP of every row before rendering
+// Rows are sorted before they reach the renderer, so the order still follows the real IPs
+type maskingRenderer struct {
+	Renderer
+	mask func(ip string) string
+}
+
+// The function masking IPs according to mode:
+// "host" hides the host portion, "hash" replaces the whole IP by a salted hash,
+// and "" (no masking) leaves IPs as they are
+func ipMask(mode string, salt string) (func(ip string) string, error) {
+	switch mode {
+	case "":
+		return func(ip string) string { return ip }, nil
+	case "host":
+		return maskHost, nil
+	case "hash":
+		return func(ip string) string {
+			return hashIP(ip, salt)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown IP mask %q (expected host or hash)", mode)
+}
+
+// Wrap renderer so that IPs are masked according to mode, see ipMask
+func newMaskingRenderer(renderer Renderer, mode string, salt string) (Renderer, error) {
+	mask, err := ipMask(mode, salt)
+	if err != nil || mode == "" {
+		return renderer, err
+	}
+	return &maskingRenderer{Renderer: renderer, mask: mask}, nil
+}
+
+func (r *maskingRenderer) Render(w io.Writer, group *subnetGroup) error {
+	return r.Renderer.Render(w, r.maskGroup(group))
+}
+
+// Copy of group with masked IPs, including the range of the subnet
+func (r *maskingRenderer) maskGroup(group *subnetGroup) *subnetGroup {
+	masked := *group
+	if group.Range != "" {
+		masked.Range = r.mask(group.Range)
+	}
+	masked.Rows = maskRows(group.Rows, r.mask)
+	return &masked
+}
+
+// Copies of rows with their IP masked by mask
+func maskRows(rows []*AddressInfo, mask func(ip string) string) []*AddressInfo {
+	masked := make([]*AddressInfo, len(rows))
+	for i, addressInfo := range rows {
+		copied := *addressInfo
+		copied.IP = mask(addressInfo.IP)
+		masked[i] = &copied
+	}
+	return masked
+}
+
+// Replace the host portion of an IP: the last octet of an IPv4 address,
+// the interface identifier (last 64 bits) of an IPv6 address
+func maskHost(ip string) string {
+	// keep the prefix length of ranges
+	if i := strings.Index(ip, "/"); i >= 0 {
+		return maskHost(ip[:i]) + ip[i:]
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "*"
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.*", v4[0], v4[1], v4[2])
+	}
+	prefix := parsed.Mask(net.CIDRMask(64, 128)).String()
+	return strings.TrimSuffix(prefix, "::") + "::*"
+}
+
+// Salted hash of an IP, stable across runs that use the same salt so reports can still be diffed
+func hashIP(ip string, salt string) string {
+	sum := sha256.Sum256([]byte(salt + ip))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (r *maskingRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	masked := make([]*subnetGroup, len(groups))
+	for i, group := range groups {
+		masked[i] = r.maskGroup(group)
+	}
+	return renderCombined(w, r.Renderer, masked)
+}
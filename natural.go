@@ -0,0 +1,66 @@
+// Ordering of subnet names
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sort subnet names, lexically or naturally (subnet-2 before subnet-10)
+func sortSubnets(subnets []string, natural bool) {
+	if natural {
+		sort.Slice(subnets, func(i, j int) bool {
+			a, b := subnets[i], subnets[j]
+			// names equal but for leading zeros (subnet-2, subnet-02) are ordered lexically
+			if naturalLess(a, b) || naturalLess(b, a) {
+				return naturalLess(a, b)
+			}
+			return a < b
+		})
+	} else {
+		sort.Strings(subnets)
+	}
+}
+
+// Compare strings treating runs of digits as numbers
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := isDigit(a[0]), isDigit(b[0])
+		switch {
+		case aDigits && bDigits:
+			aNum, aRest := splitDigits(a)
+			bNum, bRest := splitDigits(b)
+			// compare numbers by length first, then digit by digit, ignoring leading zeros
+			aTrim, bTrim := strings.TrimLeft(aNum, "0"), strings.TrimLeft(bNum, "0")
+			if len(aTrim) != len(bTrim) {
+				return len(aTrim) < len(bTrim)
+			}
+			if aTrim != bTrim {
+				return aTrim < bTrim
+			}
+			if aNum != bNum {
+				return aNum < bNum
+			}
+			a, b = aRest, bRest
+		case a[0] != b[0]:
+			return a[0] < b[0]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Split a string into its leading run of digits and the rest
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
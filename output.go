@@ -0,0 +1,287 @@
+// Where output files are written: a local directory, stdout or Cloud Storage
+//
+// The -output target decides both where files go and whether there is one
+// file per subnet or a single combined file:
+//   ""                      one file per subnet in the current directory
+//   "-"                     everything combined on stdout
+//   "<dir>" or "<dir>/"     one file per subnet in <dir>
+//   "<file>.<ext>"          everything combined in <file>.<ext>
+//   "gs://bucket/prefix/"   one object per subnet under prefix
+//   "gs://bucket/name.ext"  everything combined in a single object
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// outputSink creates the files of a run
+type outputSink interface {
+	// Create a file, which is only complete once closed
+	// Once ctx is done, writing fails and closing leaves the previous file, if any, in place
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	// Where a file ends up, for logging
+	Path(name string) string
+	// Remove a file, if there is one
+	Remove(name string) error
+}
+
+// output is where the results of a run are written
+type output struct {
+	sink   outputSink
+	single string // name of the combined file, "" for one file per subnet
+}
+
+// Base name of the single file of -single-file
+const singleFileName = "inventory"
+
+// Formats written to a single file unless -output says otherwise
+// JSON, TSV and SQLite are for programs, which are better off with a single document,
+// and a workbook has a sheet per subnet
+var singleFileFormats = map[string]bool{
+	"json":   true,
+	"tsv":    true,
+	"xlsx":   true,
+	"sqlite": true,
+}
+
+// The -output target, taking the older flags that choose an output into account
+// extension is the one of the output format, e.g. md
+func outputTarget(opts *options, extension string) (string, error) {
+	target := opts.Output
+	if opts.Stdout {
+		if target != "" || opts.OutDir != "" {
+			return "", fmt.Errorf("-stdout can't be combined with -output or -out-dir")
+		}
+		// all subnets are combined on stdout anyway
+		return "-", nil
+	}
+	if opts.OutDir != "" && target != "" {
+		return "", fmt.Errorf("-out-dir can't be combined with -output")
+	}
+	if opts.SingleFile {
+		if target != "" {
+			return "", fmt.Errorf("-single-file can't be combined with -output")
+		}
+		target = filepath.Join(opts.OutDir, singleFileName+"."+extension)
+	}
+	if target == "" && singleFileFormats[opts.Format] {
+		target = filepath.Join(opts.OutDir, singleFileName+"."+extension)
+	}
+	if target == "" && opts.OutDir != "" {
+		// a directory, even if its name looks like a file name
+		target = filepath.Clean(opts.OutDir) + string(filepath.Separator)
+	}
+	return target, nil
+}
+
+// Whether target is a Cloud Storage URI
+func isGCS(target string) bool {
+	return strings.HasPrefix(target, "gs://")
+}
+
+// Parse an -output target, see above
+// client is used for Cloud Storage targets
+// With dryRun, local directories are not created
+func parseOutput(target string, client *http.Client, dryRun bool) (*output, error) {
+	switch {
+	case target == "-":
+		return &output{sink: stdoutSink{}, single: "-"}, nil
+
+	case isGCS(target):
+		bucket := strings.TrimPrefix(target, "gs://")
+		object := ""
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, object = bucket[:i], bucket[i+1:]
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("missing bucket in %s", target)
+		}
+		storageService, err := storage.NewService(context.Background(), option.WithHTTPClient(client))
+		if err != nil {
+			return nil, err
+		}
+		if object == "" || strings.HasSuffix(object, "/") || path.Ext(object) == "" {
+			prefix := strings.TrimSuffix(object, "/")
+			if prefix != "" {
+				prefix += "/"
+			}
+			return &output{sink: &gcsSink{service: storageService, bucket: bucket, prefix: prefix}}, nil
+		}
+		dir := path.Dir(object) + "/"
+		if dir == "./" {
+			dir = ""
+		}
+		return &output{sink: &gcsSink{service: storageService, bucket: bucket, prefix: dir}, single: path.Base(object)}, nil
+	}
+
+	if target == "" {
+		target = "."
+	}
+
+	info, err := os.Stat(target)
+	isDir := err == nil && info.IsDir()
+	if isDir || strings.HasSuffix(target, "/") || strings.HasSuffix(target, string(filepath.Separator)) || filepath.Ext(target) == "" {
+		if !dryRun {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return &output{sink: dirSink(target)}, nil
+	}
+
+	dir := filepath.Dir(target)
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &output{sink: dirSink(dir), single: filepath.Base(target)}, nil
+}
+
+// dirSink writes files to a local directory
+type dirSink string
+
+// Files are written to a temporary file next to them, which replaces them when closed
+func (d dirSink) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	f, err := ioutil.TempFile(string(d), "."+name+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{File: f, ctx: ctx, path: filepath.Join(string(d), name)}, nil
+}
+
+func (d dirSink) Path(name string) string {
+	return filepath.Join(string(d), name)
+}
+
+func (d dirSink) Remove(name string) error {
+	if err := os.Remove(filepath.Join(string(d), name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// stdoutSink writes everything to stdout
+type stdoutSink struct{}
+
+// Writes to stdout can't be taken back, so ctx is ignored (see -render-timeout)
+func (stdoutSink) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return nopCloser{os.Stdout}, nil
+}
+
+func (stdoutSink) Path(name string) string {
+	return "stdout"
+}
+
+// Nothing is left behind on stdout
+func (stdoutSink) Remove(name string) error {
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// tempFile is a temporary file that is renamed to path when closed, unless ctx is done
+type tempFile struct {
+	*os.File
+	ctx  context.Context
+	path string
+}
+
+func (f *tempFile) Write(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *tempFile) Close() error {
+	// temporary files are only readable by their owner
+	err := f.File.Chmod(0644)
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = f.ctx.Err()
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.path)
+}
+
+// gcsSink uploads files as objects of a Cloud Storage bucket
+type gcsSink struct {
+	service *storage.Service
+	bucket  string
+	prefix  string // "" or ending with "/"
+}
+
+func (g *gcsSink) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	return &gcsObject{sink: g, name: g.prefix + name, ctx: ctx}, nil
+}
+
+func (g *gcsSink) Path(name string) string {
+	return "gs://" + g.bucket + "/" + g.prefix + name
+}
+
+func (g *gcsSink) Remove(name string) error {
+	err := g.service.Objects.Delete(g.bucket, g.prefix+name).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// gcsObject buffers the content of an object and uploads it when closed, unless ctx is done
+type gcsObject struct {
+	bytes.Buffer
+	sink *gcsSink
+	name string
+	ctx  context.Context
+}
+
+func (o *gcsObject) Close() error {
+	if err := o.ctx.Err(); err != nil {
+		return err
+	}
+	_, err := o.sink.service.Objects.Insert(o.sink.bucket, &storage.Object{Name: o.name}).Media(&o.Buffer).Context(o.ctx).Do()
+	return err
+}
+
+// Create name in sink and write it with render
+// Once ctx is done, the file is no longer written, see outputSink
+func writeFile(ctx context.Context, sink outputSink, name string, render func(w io.Writer) error) error {
+	w, err := sink.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := render(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	logger.Progress("Writing", "file", sink.Path(name))
+
+	return w.Close()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWriteWithTimeout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "s1.md")
+	if err := ioutil.WriteFile(path, []byte("previous run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(delay time.Duration, content string) error {
+		return writeWithTimeout(50*time.Millisecond, func(ctx context.Context) error {
+			return writeFile(ctx, dirSink(dir), "s1.md", func(w io.Writer) error {
+				time.Sleep(delay)
+				_, err := io.WriteString(w, content)
+				return err
+			})
+		})
+	}
+
+	if err := write(200*time.Millisecond, "late"); err == nil {
+		t.Fatal("slow write wasn't abandoned")
+	}
+	// the abandoned write fails once it gets to writing, and cleans up after itself
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files left in the output directory, want 1", len(files))
+		}
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "previous run" {
+		t.Errorf("abandoned write left %q, want the previous file", content)
+	}
+
+	if err := write(0, "fast"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "fast" {
+		t.Errorf("file holds %q, want %q", content, "fast")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file mode %v, want 0644", info.Mode().Perm())
+	}
+}
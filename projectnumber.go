@@ -0,0 +1,46 @@
+// Project numbers of the scanned projects
+//
+// Audit logs and IAM policies often refer to projects by number rather than
+// by ID, so the number helps correlating the report with them.
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Get the number of each of the given projects, keyed by project ID
+func getProjectNumbers(ctx context.Context, projectResourceList []*projectResources, service *compute.Service, opts *options) (map[string]string, []string) {
+	numbers := make(map[string]string)
+	var errs []string
+
+	for _, p := range projectResourceList {
+		var project *compute.Project
+		err := gcpips.WithRetry(ctx, &opts.Options, "getting project "+p.Project, func() (err error) {
+			project, err = service.Projects.Get(p.Project).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			logger.Error("Error getting the project number", "project", p.Project, "error", err)
+			errs = append(errs, p.Project+": error getting project number: "+err.Error())
+			continue
+		}
+		numbers[p.Project] = strconv.FormatUint(project.Id, 10)
+	}
+
+	return numbers, errs
+}
+
+// Set the ProjectNumber field of all addresses
+// Addresses of projects whose number is unknown are left blank
+func annotateProjectNumbers(addressesBySubnet map[string][]*AddressInfo, numbers map[string]string) {
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			addressInfo.ProjectNumber = numbers[addressInfo.Project]
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Subnet utilization gauges for the Prometheus node exporter textfile collector
+//
+// Point the collector at a file written with -format prometheus-textfile
+// and -output, e.g. -output /var/lib/node_exporter/textfile/gcp-ips.prom
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"google.golang.org/api/compute/v1"
+)
+
+// Addresses of every subnet that Google Cloud reserves: the network and gateway
+// addresses, the second-to-last address and the broadcast address
+const reservedSubnetAddresses = 4
+
+// Number of addresses of a subnet's primary range that can be assigned
+// 0 when the range isn't known or valid
+func usableHosts(subnetwork *compute.Subnetwork) *big.Int {
+	usable := new(big.Int)
+	if subnetwork == nil {
+		return usable
+	}
+	_, ipNet, err := net.ParseCIDR(subnetwork.IpCidrRange)
+	if err != nil {
+		return usable
+	}
+	ones, bits := ipNet.Mask.Size()
+	usable.Lsh(big.NewInt(1), uint(bits-ones))
+	usable.Sub(usable, big.NewInt(reservedSubnetAddresses))
+	if usable.Sign() < 0 {
+		usable.SetInt64(0)
+	}
+	return usable
+}
+
+// Number of listed addresses in a subnet's primary range
+// External IPs, which are listed with the subnet of their instance, don't count
+// When the range isn't known, all listed addresses count
+func usedIPs(group *subnetGroup) int {
+	if group.Details == nil {
+		return len(group.Rows)
+	}
+	_, ipNet, err := net.ParseCIDR(group.Details.IpCidrRange)
+	if err != nil {
+		return len(group.Rows)
+	}
+	used := 0
+	for _, addressInfo := range group.Rows {
+		if ip := parseAddr(addressInfo.IP); ip != nil && ipNet.Contains(ip) {
+			used++
+		}
+	}
+	return used
+}
+
+// promRenderer writes the gcp_subnet_used_ips and gcp_subnet_total_ips gauges
+type promRenderer struct{}
+
+func (r *promRenderer) Extension() string {
+	return "prom"
+}
+
+func (r *promRenderer) Render(w io.Writer, group *subnetGroup) error {
+	return r.RenderCombined(w, []*subnetGroup{group})
+}
+
+// Gauges of all subnets, with a single HELP and TYPE line per metric
+func (r *promRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	if _, err := io.WriteString(w, "# HELP gcp_subnet_used_ips Listed IPs in the primary range of the subnet\n# TYPE gcp_subnet_used_ips gauge\n"); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if _, err := fmt.Fprintf(w, "gcp_subnet_used_ips%s %d\n", promLabels(group), group.Used); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, "# HELP gcp_subnet_total_ips Usable IPs in the primary range of the subnet\n# TYPE gcp_subnet_total_ips gauge\n"); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		// unknown for subnets that couldn't be looked up
+		if group.Details == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "gcp_subnet_total_ips%s %s\n", promLabels(group), usableHosts(group.Details)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escapes a label value for the text format, which only escapes backslashes, double quotes and line feeds
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels of the gauges of a subnet
+func promLabels(group *subnetGroup) string {
+	region := ""
+	if group.Details != nil {
+		region = gcpips.GetName(group.Details.Region)
+	}
+	return fmt.Sprintf(`{subnet="%s",region="%s"}`, promLabelEscaper.Replace(group.Name), promLabelEscaper.Replace(region))
+}
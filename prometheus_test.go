@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestPromLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"vpc1__s1", `{subnet="vpc1__s1",region="us-central1"}`},
+		// only backslashes, double quotes and line feeds are escaped
+		{"sous-réseau", `{subnet="sous-réseau",region="us-central1"}`},
+		{`a\b"c` + "\nd\t", `{subnet="a\\b\"c\nd` + "\t" + `",region="us-central1"}`},
+	}
+	details := &compute.Subnetwork{Region: "https://www.googleapis.com/compute/v1/projects/host/regions/us-central1"}
+	for _, test := range tests {
+		if got := promLabels(&subnetGroup{Name: test.name, Details: details}); got != test.want {
+			t.Errorf("promLabels(%q) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
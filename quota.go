@@ -0,0 +1,130 @@
+// Checks of reserved external IPs against the per-region STATIC_ADDRESSES quota
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Regions using at least this fraction of their static address quota are flagged
+const quotaWarnRatio = 0.8
+
+// regionQuota holds the static address quota of a project in a region
+type regionQuota struct {
+	Project  string
+	Region   string
+	Reserved int     // external addresses reserved in the region, as seen in the address list
+	Usage    float64 // usage reported by the quota itself
+	Limit    float64
+}
+
+// Whether the region is close to (or at) its limit
+func (q *regionQuota) nearLimit() bool {
+	return q.Limit > 0 && q.Usage >= q.Limit*quotaWarnRatio
+}
+
+// Count the reserved external addresses of each project by region and look up
+// the STATIC_ADDRESSES quota of every region that has any
+// Regions whose quota couldn't be looked up are left out, and returned as errors
+func getQuotas(ctx context.Context, projectResourceList []*projectResources, service *compute.Service, opts *options) ([]*regionQuota, []string) {
+	var quotas []*regionQuota
+	var errs []string
+
+	for _, p := range projectResourceList {
+		if p.AddressList == nil {
+			continue
+		}
+
+		reserved := make(map[string]int)
+		for scope, addressScopedList := range p.AddressList.Items {
+			kind, region := gcpips.ParseScope(scope)
+			if kind != "regions" {
+				continue
+			}
+			for _, address := range addressScopedList.Addresses {
+				if address.AddressType == "EXTERNAL" {
+					reserved[region]++
+				}
+			}
+		}
+
+		for region, count := range reserved {
+			var r *compute.Region
+			err := gcpips.WithRetry(ctx, &opts.Options, "getting quotas of "+p.Project, func() (err error) {
+				r, err = service.Regions.Get(p.Project, region).Context(ctx).Do()
+				return err
+			})
+			if err != nil {
+				logger.Error("Error getting quotas", "project", p.Project, "region", region, "error", err)
+				errs = append(errs, fmt.Sprintf("%s: error getting quotas of %s: %s", p.Project, region, err))
+				continue
+			}
+			for _, quota := range r.Quotas {
+				if quota.Metric == "STATIC_ADDRESSES" {
+					quotas = append(quotas, &regionQuota{
+						Project:  p.Project,
+						Region:   region,
+						Reserved: count,
+						Usage:    quota.Usage,
+						Limit:    quota.Limit,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Project != quotas[j].Project {
+			return quotas[i].Project < quotas[j].Project
+		}
+		return quotas[i].Region < quotas[j].Region
+	})
+	// regions are looked up in map order
+	sort.Strings(errs)
+
+	return quotas, errs
+}
+
+// Log the static address quota of each region, flagging the ones near their limit
+func logQuotas(quotas []*regionQuota) {
+	for _, q := range quotas {
+		args := []any{"project", q.Project, "region", q.Region, "reserved", q.Reserved, "usage", q.Usage, "limit", q.Limit}
+		if q.nearLimit() {
+			logger.Warn("Static address quota near limit", args...)
+		} else {
+			logger.Info("Static address quota", args...)
+		}
+	}
+}
+
+// Static addresses that can still be reserved in the region
+func (q *regionQuota) headroom() string {
+	headroom := fmt.Sprintf("%.0f", q.Limit-q.Usage)
+	if q.nearLimit() {
+		headroom += " (near limit)"
+	}
+	return headroom
+}
+
+// Set the Headroom field of the reserved external addresses, from the quota of their project and region
+func annotateHeadroom(addressesBySubnet map[string][]*AddressInfo, quotas []*regionQuota) {
+	byRegion := make(map[string]*regionQuota)
+	for _, q := range quotas {
+		byRegion[q.Project+"/"+q.Region] = q
+	}
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Type != "EXTERNAL" {
+				continue
+			}
+			if q, ok := byRegion[addressInfo.Project+"/"+addressInfo.Region]; ok {
+				addressInfo.Headroom = q.headroom()
+			}
+		}
+	}
+}
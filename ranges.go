@@ -0,0 +1,42 @@
+// Ordering of addresses, including reserved ranges (addresses with a prefix length)
+
+package main
+
+import (
+	"bytes"
+	"net"
+)
+
+// Parse an IP, or the first address of a CIDR, for sorting
+// A CIDR written with host bits (e.g. 10.0.1.5/24) sorts by its first address (10.0.1.0)
+func parseAddr(addr string) net.IP {
+	if _, ipnet, err := net.ParseCIDR(addr); err == nil {
+		return ipnet.IP
+	}
+	return net.ParseIP(addr)
+}
+
+// Compare two addresses from parseAddr, for sorting
+// IPv4 addresses come before IPv6 ones, and addresses of the same family are in numeric order
+// (both are compared in their 16-byte form, so an IPv4 address compares the same however it was parsed)
+// Unparseable (nil) addresses come last
+func compareAddrs(a net.IP, b net.IP) int {
+	switch {
+	case a == nil || b == nil:
+		return boolCompare(a == nil, b == nil)
+	case (a.To4() == nil) != (b.To4() == nil):
+		return boolCompare(a.To4() == nil, b.To4() == nil)
+	}
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// Compare two booleans, false first
+func boolCompare(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompareAddrs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"10.0.0.2", "10.0.0.10", -1},
+		{"10.0.0.10", "10.0.0.2", 1},
+		{"10.0.0.2", "10.0.0.2", 0},
+		// IPv4 before IPv6, whatever their bytes
+		{"255.255.255.255", "::1", -1},
+		{"2600:1900::", "10.0.0.1", 1},
+		// compressed and expanded IPv6 are the same address
+		{"2600:1900::", "2600:1900:0:0:0:0:0:0", 0},
+		{"2600:1900::", "2600:1900::1", -1},
+		{"2600:1900::ffff", "2600:1901::", -1},
+		// IPv4-mapped IPv6 compares as IPv4
+		{"::ffff:10.0.0.1", "10.0.0.1", 0},
+		// ranges sort by their network address
+		{"10.0.1.0/24", "10.0.0.255", 1},
+		{"10.0.1.0/24", "10.0.1.1", -1},
+		{"10.0.1.0/24", "10.0.1.0", 0},
+		{"10.0.1.5/24", "10.0.1.0", 0},
+		{"2600:1900::/64", "2600:1900::1", -1},
+		// unparseable addresses come last
+		{"", "10.0.0.1", 1},
+		{"not-an-ip", "::1", 1},
+		{"not-an-ip", "", 0},
+	}
+	for _, test := range tests {
+		if got := compareAddrs(parseAddr(test.a), parseAddr(test.b)); got != test.want {
+			t.Errorf("compareAddrs(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSortByIP(t *testing.T) {
+	addrs := []string{"2600:1900::1", "10.0.0.10", "bogus", "10.0.1.0/24", "2600:1900::", "10.0.0.2", "192.168.0.0/16"}
+	want := []string{"10.0.0.2", "10.0.0.10", "10.0.1.0/24", "192.168.0.0/16", "2600:1900::", "2600:1900::1", "bogus"}
+
+	var rows []*AddressInfo
+	for _, addr := range addrs {
+		rows = append(rows, &AddressInfo{IP: addr})
+	}
+	sortByIP(rows)
+	var got []string
+	for _, row := range rows {
+		got = append(got, row.IP)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sorted %v, want %v", got, want)
+	}
+}
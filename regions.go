@@ -0,0 +1,100 @@
+// Filtering of the aggregated lists by region
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"google.golang.org/api/compute/v1"
+)
+
+// Region of a zone, e.g. us-central1-a -> us-central1
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i >= 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// Region of an aggregated list scope key, "" for global scopes
+func scopeRegion(key string) string {
+	kind, name := gcpips.ParseScope(key)
+	switch kind {
+	case "regions":
+		return name
+	case "zones":
+		return zoneRegion(name)
+	}
+	return ""
+}
+
+// Split a comma-separated list of regions
+func splitRegions(list string) []string {
+	var regions []string
+	for _, region := range strings.Split(list, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// Read a list of regions, one per line
+// Blank lines and lines starting with # are ignored
+func readRegionsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var regions []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			regions = append(regions, line)
+		}
+	}
+	return regions, scanner.Err()
+}
+
+// Drop the scopes of the aggregated lists that are outside the given regions
+// Zones match their region, global scopes never match
+func filterRegions(projectResourceList []*projectResources, regions map[string]bool) {
+	for _, p := range projectResourceList {
+		if p.AddressList != nil {
+			for scope := range p.AddressList.Items {
+				if !regions[scopeRegion(scope)] {
+					delete(p.AddressList.Items, scope)
+				}
+			}
+		}
+		if p.InstanceList != nil {
+			for scope := range p.InstanceList.Items {
+				if !regions[scopeRegion(scope)] {
+					delete(p.InstanceList.Items, scope)
+				}
+			}
+		}
+		if p.ForwardingRuleList != nil {
+			for scope := range p.ForwardingRuleList.Items {
+				if !regions[scopeRegion(scope)] {
+					delete(p.ForwardingRuleList.Items, scope)
+				}
+			}
+		}
+	}
+}
+
+// Drop the subnets outside the given regions
+func filterSubnetRegions(subnets map[string]*compute.Subnetwork, regions map[string]bool) {
+	for name, subnetwork := range subnets {
+		if !regions[gcpips.GetName(subnetwork.Region)] {
+			delete(subnets, name)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Output formats for the per-subnet address lists
+//
+// Each format implements the Renderer interface, so adding a format means
+// adding a Renderer here and registering it in newRenderer
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/sosimon/gcp-ips/gcpips"
+)
+
+// Renderer formats the (already sorted) addresses of a subnet and writes them to w
+type Renderer interface {
+	// File extension, without the dot, of the files written by this renderer
+	Extension() string
+	Render(w io.Writer, group *subnetGroup) error
+}
+
+// combinedRenderer is implemented by formats that render all subnets into a single file
+// differently than one subnet after the other, e.g. with a single header row
+type combinedRenderer interface {
+	RenderCombined(w io.Writer, groups []*subnetGroup) error
+}
+
+// Render all groups to w, as a single document
+func renderCombined(w io.Writer, renderer Renderer, groups []*subnetGroup) error {
+	if c, ok := renderer.(combinedRenderer); ok {
+		return c.RenderCombined(w, groups)
+	}
+	for _, group := range groups {
+		if err := renderer.Render(w, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the Renderer for a --format value
+// columns are used by the tabular formats, with names shortened to truncate characters
+// in the Markdown and HTML tables (see truncateNames)
+// compactJSON writes JSON on a single line instead of indented
+func newRenderer(format string, columns []column, truncate int, compactJSON bool) (Renderer, error) {
+	switch format {
+	case "markdown", "md":
+		return &markdownRenderer{columns: truncateNames(columns, truncate), heading: "#"}, nil
+	case "csv":
+		return &csvRenderer{columns: columns}, nil
+	case "tsv":
+		return &tsvRenderer{columns: columns}, nil
+	case "xlsx":
+		return &xlsxRenderer{columns: columns}, nil
+	case "sqlite":
+		return &sqliteRenderer{}, nil
+	case "html":
+		return &htmlRenderer{columns: truncateNames(columns, truncate)}, nil
+	case "json":
+		return &jsonRenderer{compact: compactJSON}, nil
+	case "prometheus-textfile":
+		return &promRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (expected markdown, csv, tsv, html, xlsx, json, sqlite or prometheus-textfile)", format)
+}
+
+// "enabled" or "disabled"
+func enabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// Used and usable addresses of the primary range of a subnet, e.g. "42 / 252 (16.7%)"
+func utilization(group *subnetGroup) string {
+	usable := usableHosts(group.Details)
+	if usable.Sign() == 0 {
+		return fmt.Sprintf("%d / %s", group.Used, usable)
+	}
+	percent, _ := new(big.Float).Quo(big.NewFloat(float64(group.Used)*100), new(big.Float).SetInt(usable)).Float64()
+	return fmt.Sprintf("%d / %s (%.1f%%)", group.Used, usable, percent)
+}
+
+// Longest value of free-text columns in Markdown tables, by column name, to keep them readable
+// Other formats have the full text
+var markdownWidths = map[string]int{
+	"description": 60,
+}
+
+// column is a column of the tabular formats
+type column struct {
+	Name   string // key of the column in -rename-columns, e.g. ip
+	Header string
+	Value  func(*AddressInfo) string
+}
+
+// Columns of the tabular formats for the given options
+func tableColumns(opts *options) []column {
+	columns := []column{
+		{"ip", "IP", func(a *AddressInfo) string { return a.IP }},
+		{"project", "GCP Project", func(a *AddressInfo) string { return a.Project }},
+		{"status", "Status", func(a *AddressInfo) string { return a.Status }},
+		{"type", "Type", func(a *AddressInfo) string { return a.Type }},
+		{"purpose", "Purpose", func(a *AddressInfo) string { return a.Purpose }},
+		{"user", "User", func(a *AddressInfo) string { return a.User }},
+		{"description", "Description", func(a *AddressInfo) string { return a.Description }},
+	}
+	// CSV and TSV rows are often combined across subnets, in a single file or a spreadsheet,
+	// and the rows of other groupings than by subnet have several subnets
+	if opts.Format == "csv" || opts.Format == "tsv" || opts.GroupBy != gcpips.GroupBySubnet {
+		columns = append(columns, column{"subnet", "Subnet", func(a *AddressInfo) string { return a.Subnet }})
+	}
+	columns = append(columns, []column{
+		{"location", "Location", func(a *AddressInfo) string { return a.Location }},
+		{"zone", "Zone", func(a *AddressInfo) string { return a.Zone }},
+		{"access-config", "Access Config", func(a *AddressInfo) string { return a.AccessConfig }},
+		{"created", "Created", createdDate},
+	}...)
+	if opts.Age {
+		columns = append(columns, column{"age", "Age (days)", ageDays})
+	}
+	if opts.IncludeAliases {
+		columns = append(columns, column{"range", "Secondary Range", func(a *AddressInfo) string { return a.RangeName }})
+	}
+	if opts.ProjectNumber {
+		columns = append(columns, column{"project-number", "Project Number", func(a *AddressInfo) string { return a.ProjectNumber }})
+	}
+	if opts.Quota {
+		columns = append(columns, column{"headroom", "Region Headroom", func(a *AddressInfo) string { return a.Headroom }})
+	}
+	if opts.BYOIP {
+		columns = append(columns, column{"announced", "Announced", func(a *AddressInfo) string { return a.Announced }})
+	}
+	if opts.SoleTenancy {
+		columns = append(columns, column{"node", "Sole-tenant Node", func(a *AddressInfo) string { return a.Node }})
+	}
+	if opts.LabelColumn != "" {
+		columns = append(columns, column{"label", opts.LabelColumn, func(a *AddressInfo) string { return a.Label }})
+	}
+	if opts.MetadataColumn != "" {
+		columns = append(columns, column{"metadata", opts.MetadataColumn, func(a *AddressInfo) string { return a.Metadata }})
+	}
+	for i := range columns {
+		if header, ok := opts.renames[columns[i].Name]; ok {
+			columns[i].Header = header
+		}
+	}
+	return columns
+}
+
+// Columns with the user and project names shortened to n characters (-truncate-names)
+// Only the Markdown and HTML tables, which are read by people, are shortened; the other
+// formats keep the full names, so that rows can be matched with the resources in GCP
+// n <= 0 means no limit
+func truncateNames(columns []column, n int) []column {
+	if n <= 0 {
+		return columns
+	}
+	truncated := make([]column, len(columns))
+	copy(truncated, columns)
+	for i, c := range truncated {
+		if c.Name == "project" || c.Name == "user" {
+			value := c.Value
+			truncated[i].Value = func(a *AddressInfo) string { return truncate(value(a), n) }
+		}
+	}
+	return truncated
+}
+
+// Parse a -rename-columns mapping of column names to headers, e.g. "ip=Address,user=Owner"
+func parseRenames(spec string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid mapping %q (expected column=Header)", pair)
+		}
+		renames[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return renames, nil
+}
+
+// Check that every renamed column is one of columns
+func validateRenames(renames map[string]string, columns []column) error {
+	known := make(map[string]bool)
+	var names []string
+	for _, c := range columns {
+		known[c.Name] = true
+		names = append(names, c.Name)
+	}
+	for name := range renames {
+		if !known[name] {
+			return fmt.Errorf("unknown column %q (columns: %s)", name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// Date (in UTC) the IP was reserved or its instance created, see creationTime
+func createdDate(a *AddressInfo) string {
+	created := creationTime(a)
+	if created.IsZero() {
+		return ""
+	}
+	return created.UTC().Format("2006-01-02")
+}
+
+// Number of whole days since the IP was reserved or its instance created, see creationTime
+func ageDays(a *AddressInfo) string {
+	created := creationTime(a)
+	if created.IsZero() {
+		return ""
+	}
+	return strconv.Itoa(int(time.Since(created).Hours() / 24))
+}
+
+// Shorten s to at most n characters, ending with an ellipsis when shortened
+// n <= 0 means no limit
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// Column headers
+func tableHeader(columns []column) []string {
+	var header []string
+	for _, c := range columns {
+		header = append(header, c.Header)
+	}
+	return header
+}
+
+// Convert addresses to table rows, one value per column
+func tableData(columns []column, rows []*AddressInfo) [][]string {
+	var data [][]string
+	for _, addressInfo := range rows {
+		var row []string
+		for _, c := range columns {
+			row = append(row, c.Value(addressInfo))
+		}
+		data = append(data, row)
+	}
+	return data
+}
+
+// markdownRenderer writes a Markdown header and table
+type markdownRenderer struct {
+	columns []column
+	heading string // heading marker, "#" for a document per subnet, "##" for a section of a larger one
+}
+
+func (r *markdownRenderer) Extension() string {
+	return "md"
+}
+
+func (r *markdownRenderer) Render(w io.Writer, group *subnetGroup) error {
+	// Write header
+	if _, err := io.WriteString(w, r.heading+" Reserved IPs for "+subnetTitle(group)+"\n"); err != nil {
+		return err
+	}
+	if group.Details != nil {
+		_, err := fmt.Fprintf(w, "\nUsed: %s\n\nPrivate Google access: %s, flow logs: %s\n\n",
+			utilization(group), enabled(group.Details.PrivateIpGoogleAccess), enabled(flowLogsEnabled(group.Details)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write data
+	writeMarkdownTable(w, r.columns, group.Rows)
+
+	return nil
+}
+
+// All subnets in one document, one section per subnet
+func (r *markdownRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	if _, err := io.WriteString(w, r.heading+" IP inventory\n\n"); err != nil {
+		return err
+	}
+	section := &markdownRenderer{columns: r.columns, heading: r.heading + "#"}
+	for _, group := range groups {
+		if err := section.Render(w, group); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Name of the subnet of group, followed by its range when known, e.g. "web-subnet (10.0.1.0/24)"
+func subnetTitle(group *subnetGroup) string {
+	if group.Range == "" {
+		return group.Name
+	}
+	return group.Name + " (" + group.Range + ")"
+}
+
+// Write rows as a Markdown table
+func writeMarkdownTable(w io.Writer, columns []column, rows []*AddressInfo) {
+	data := tableData(columns, rows)
+	for _, row := range data {
+		for i, c := range columns {
+			if width, ok := markdownWidths[c.Name]; ok {
+				// on one line, as a line break would split the table row
+				row[i] = truncate(strings.Join(strings.Fields(row[i]), " "), width)
+			}
+		}
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(tableHeader(columns))
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// csvRenderer writes a header row followed by one row per address
+type csvRenderer struct {
+	columns []column
+}
+
+func (r *csvRenderer) Extension() string {
+	return "csv"
+}
+
+func (r *csvRenderer) Render(w io.Writer, group *subnetGroup) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(tableHeader(r.columns)); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(tableData(r.columns, group.Rows)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// All subnets in one table, with a single header row
+func (r *csvRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(tableHeader(r.columns)); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := cw.WriteAll(tableData(r.columns, group.Rows)); err != nil {
+			return err
+		}
+	}
+	return cw.Error()
+}
+
+// tsvRenderer writes tab-separated values: a header row followed by one row per address
+// There is no quoting, tabs and line breaks in values are replaced by spaces instead
+type tsvRenderer struct {
+	columns []column
+}
+
+// Replaces the characters that would break a TSV row
+var tsvReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+func (r *tsvRenderer) Extension() string {
+	return "tsv"
+}
+
+func (r *tsvRenderer) Render(w io.Writer, group *subnetGroup) error {
+	return r.RenderCombined(w, []*subnetGroup{group})
+}
+
+// All subnets in one table, with a single header row
+func (r *tsvRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	if err := writeTSVRow(w, tableHeader(r.columns)); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		for _, row := range tableData(r.columns, group.Rows) {
+			if err := writeTSVRow(w, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write a row of values separated by tabs
+func writeTSVRow(w io.Writer, values []string) error {
+	cleaned := make([]string, len(values))
+	for i, value := range values {
+		cleaned[i] = tsvReplacer.Replace(value)
+	}
+	_, err := io.WriteString(w, strings.Join(cleaned, "\t")+"\n")
+	return err
+}
+
+// jsonRenderer writes the addresses as a JSON array, indented unless compact
+type jsonRenderer struct {
+	compact bool
+}
+
+func (r *jsonRenderer) Extension() string {
+	return "json"
+}
+
+func (r *jsonRenderer) Render(w io.Writer, group *subnetGroup) error {
+	// an empty subnet is an empty array, not null
+	rows := group.Rows
+	if rows == nil {
+		rows = []*AddressInfo{}
+	}
+	var out []byte
+	var err error
+	if r.compact {
+		out, err = json.Marshal(rows)
+	} else {
+		out, err = json.MarshalIndent(rows, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// All subnets in one JSON array
+func (r *jsonRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	rows := []*AddressInfo{}
+	for _, group := range groups {
+		rows = append(rows, group.Rows...)
+	}
+	return r.Render(w, &subnetGroup{Rows: rows})
+}
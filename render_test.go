@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJSONRenderEmptyGroup(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		renderer := &jsonRenderer{compact: compact}
+		var buf bytes.Buffer
+		if err := renderer.Render(&buf, &subnetGroup{Name: "vpc1__s1"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != "[]\n" {
+			t.Errorf("compact %v: %q, want %q", compact, got, "[]\n")
+		}
+		buf.Reset()
+		if err := renderer.RenderCombined(&buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != "[]\n" {
+			t.Errorf("compact %v, no groups: %q, want %q", compact, got, "[]\n")
+		}
+	}
+}
+
+func TestTruncateNames(t *testing.T) {
+	const user = "a-very-long-instance-name-1"
+	group := &subnetGroup{Name: "vpc1__s1", Rows: []*AddressInfo{{IP: "10.0.0.2", Project: "svc", User: user}}}
+	for _, format := range []string{"markdown", "html", "csv", "tsv", "json"} {
+		opts := &options{Format: format, TruncateNames: 10}
+		renderer, err := newRenderer(format, tableColumns(opts), opts.TruncateNames, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := renderer.Render(&buf, group); err != nil {
+			t.Fatal(err)
+		}
+		// only the tables people read are shortened
+		readable := format == "markdown" || format == "html"
+		if got := strings.Contains(buf.String(), "a-very-lo…"); got != readable {
+			t.Errorf("%s: shortened name %v, want %v:\n%s", format, got, readable, buf.String())
+		}
+		if got := strings.Contains(buf.String(), user); got == readable {
+			t.Errorf("%s: full name %v, want %v:\n%s", format, got, !readable, buf.String())
+		}
+	}
+}
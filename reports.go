@@ -0,0 +1,104 @@
+// Reports on specific addresses that need attention, written next to the per-subnet files
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// Write rows to a Markdown report in sink with the given title, sorted by IP
+// IPs are masked with mask (see ipMask), after sorting so that the order follows the real IPs
+func writeReport(sink outputSink, filename string, title string, columns []column, rows []*AddressInfo, mask func(ip string) string) error {
+	sortByIP(rows)
+	rows = maskRows(rows, mask)
+	return writeFile(context.Background(), sink, filename, func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "# %s\n\n", title); err != nil {
+			return err
+		}
+		writeMarkdownTable(w, columns, rows)
+		return nil
+	})
+}
+
+// Remove the report of a previous run, for a run with nothing to report
+// Left next to the new output, it would look current
+func removeReport(sink outputSink, filename string) error {
+	return sink.Remove(filename)
+}
+
+// Report of the IPs claimed by more than one resource
+const conflictsReportFile = "conflicts.md"
+
+// Write a report of the IPs claimed by more than one resource, e.g. two instances in
+// different service projects with the same internal IP, which is a misconfiguration
+// Each IP has a row for the entry that was listed and one for each conflicting claimant
+// Nothing is written when there is no conflict, and the report of a previous run is removed
+func writeConflictsReport(sink outputSink, addressesBySubnet map[string][]*AddressInfo, columns []column, mask func(ip string) string) error {
+	var rows []*AddressInfo
+	conflicts := 0
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if len(addressInfo.Conflicts) == 0 {
+				continue
+			}
+			conflicts++
+			rows = append(rows, addressInfo)
+			rows = append(rows, addressInfo.Conflicts...)
+			for _, other := range addressInfo.Conflicts {
+				logger.Warn("IP claimed by more than one resource", "ip", mask(addressInfo.IP),
+					"project", addressInfo.Project, "user", addressInfo.User, "subnet", addressInfo.Subnet,
+					"other_project", other.Project, "other_user", other.User, "other_subnet", other.Subnet)
+			}
+		}
+	}
+
+	if conflicts == 0 {
+		return removeReport(sink, conflictsReportFile)
+	}
+
+	// the claimants can be in different subnets
+	hasSubnet := false
+	for _, c := range columns {
+		hasSubnet = hasSubnet || c.Name == "subnet"
+	}
+	if !hasSubnet {
+		columns = append(columns, column{"subnet", "Subnet", func(a *AddressInfo) string { return a.Subnet }})
+	}
+
+	logger.Warn("IPs are claimed by more than one resource", "ips", conflicts, "report", conflictsReportFile)
+	return writeReport(sink, conflictsReportFile, "IPs claimed by more than one resource", columns, rows, mask)
+}
+
+// Report of addresses stuck in a transient or error state
+const stuckReportFile = "stuck.md"
+
+// Statuses an address is expected to settle in
+// Any other status (e.g. RESERVING) is reported as stuck; add statuses here to stop reporting them
+var settledStatuses = map[string]bool{
+	"RESERVED": true,
+	"IN_USE":   true,
+}
+
+// Write a report of the reserved addresses whose status is not a settled one
+// Instance IPs have no status and are never reported
+// Nothing is written when no address is stuck, and the report of a previous run is removed
+func writeStuckReport(sink outputSink, addressesBySubnet map[string][]*AddressInfo, columns []column, mask func(ip string) string) error {
+	var stuck []*AddressInfo
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Status != "" && !settledStatuses[addressInfo.Status] {
+				stuck = append(stuck, addressInfo)
+			}
+		}
+	}
+
+	if len(stuck) == 0 {
+		return removeReport(sink, stuckReportFile)
+	}
+
+	logger.Warn("Addresses are not RESERVED or IN_USE", "addresses", len(stuck), "report", stuckReportFile)
+	return writeReport(sink, stuckReportFile, "Addresses stuck in a transient or error state", columns, stuck, mask)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReportsOfPreviousRunsAreRemoved(t *testing.T) {
+	sink := dirSink(t.TempDir())
+	columns := tableColumns(&options{})
+	mask, _ := ipMask("", "")
+	run := func(addressesBySubnet map[string][]*AddressInfo) {
+		if err := writeStuckReport(sink, addressesBySubnet, columns, mask); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeConflictsReport(sink, addressesBySubnet, columns, mask); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(sink.Path(name))
+		return err == nil
+	}
+
+	run(map[string][]*AddressInfo{"s1": {
+		{IP: "10.0.0.2", Status: "RESERVING"},
+		{IP: "10.0.0.3", Project: "svc-1", Conflicts: []*AddressInfo{{IP: "10.0.0.3", Project: "svc-2"}}},
+	}})
+	for _, name := range []string{stuckReportFile, conflictsReportFile} {
+		if !exists(name) {
+			t.Errorf("%s not written", name)
+		}
+	}
+
+	// the second run has nothing to report
+	run(map[string][]*AddressInfo{"s1": {
+		{IP: "10.0.0.2", Status: "IN_USE"},
+		{IP: "10.0.0.3", Project: "svc-1"},
+	}})
+	for _, name := range []string{stuckReportFile, conflictsReportFile} {
+		if exists(name) {
+			t.Errorf("%s of the first run left behind", name)
+		}
+	}
+
+	// and neither has the third one
+	run(map[string][]*AddressInfo{})
+}
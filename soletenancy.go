@@ -0,0 +1,83 @@
+// Attribution of instance IPs to the sole-tenant nodes the instances run on
+//
+// Sole-tenant node groups don't hold IPs themselves, but the instances placed
+// on their nodes do. Knowing the node tells which dedicated hardware (and
+// which node group's owner) an IP belongs to.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/sosimon/gcp-ips/gcpips"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// Key of an instance, from its project, zone and name
+func instanceKey(project string, zone string, name string) string {
+	return project + "/" + zone + "/" + name
+}
+
+// Key of an instance from its URL (.../projects/<project>/zones/<zone>/instances/<name>)
+func instanceURLKey(url string) string {
+	parts := strings.Split(url, "/")
+	if len(parts) < 6 {
+		return url
+	}
+	return instanceKey(parts[len(parts)-5], parts[len(parts)-3], parts[len(parts)-1])
+}
+
+// Get the sole-tenant node ("node-group/node") of every instance running on one, keyed by instanceKey
+func getSoleTenantNodes(ctx context.Context, projectResourceList []*projectResources, service *compute.Service, opts *options) (map[string]string, []string) {
+	nodes := make(map[string]string)
+	var errs []string
+
+	for _, p := range projectResourceList {
+		var nodeGroupsByScope map[string][]*compute.NodeGroup
+		err := gcpips.WithRetry(ctx, &opts.Options, "getting node groups of "+p.Project, func() (err error) {
+			nodeGroupsByScope, err = gcpips.AllNodeGroupPages(ctx, service.NodeGroups.AggregatedList(p.Project))
+			return err
+		})
+		if err != nil {
+			logger.Error("Error getting node groups", "project", p.Project, "error", err)
+			errs = append(errs, p.Project+": error getting node groups: "+err.Error())
+			continue
+		}
+
+		for scope, nodeGroups := range nodeGroupsByScope {
+			_, zone := gcpips.ParseScope(scope)
+			for _, nodeGroup := range nodeGroups {
+				var nodeList []*compute.NodeGroupNode
+				err := gcpips.WithRetry(ctx, &opts.Options, "getting nodes of "+nodeGroup.Name, func() (err error) {
+					nodeList, err = gcpips.AllNodePages(ctx, service.NodeGroups.ListNodes(p.Project, zone, nodeGroup.Name))
+					return err
+				})
+				if err != nil {
+					logger.Error("Error getting nodes", "node_group", nodeGroup.Name, "project", p.Project, "error", err)
+					errs = append(errs, p.Project+": error getting nodes of "+nodeGroup.Name+": "+err.Error())
+					continue
+				}
+				for _, node := range nodeList {
+					for _, instance := range node.Instances {
+						nodes[instanceURLKey(instance)] = nodeGroup.Name + "/" + node.Name
+					}
+				}
+			}
+		}
+	}
+
+	return nodes, errs
+}
+
+// Set the Node field of instance IPs whose instance runs on a sole-tenant node
+func annotateNodes(addressesBySubnet map[string][]*AddressInfo, nodes map[string]string) {
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			if addressInfo.Zone == "" {
+				continue
+			}
+			addressInfo.Node = nodes[instanceKey(addressInfo.Project, addressInfo.Zone, addressInfo.User)]
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// SQLite database output, for ad-hoc SQL queries over the inventory, e.g.
+//
+//	SELECT project, COUNT(*) FROM addresses WHERE status = 'RESERVED' GROUP BY project ORDER BY 2 DESC
+//
+// The pure Go driver doesn't need cgo, so the tool still cross-compiles.
+
+package main
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// Schema of the database, created from scratch on every run
+const sqliteSchema = `
+CREATE TABLE addresses (
+	subnet TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	project TEXT NOT NULL,
+	status TEXT NOT NULL,
+	user TEXT NOT NULL,
+	location TEXT NOT NULL
+);
+CREATE INDEX addresses_ip ON addresses (ip);
+CREATE INDEX addresses_subnet ON addresses (subnet);
+`
+
+// sqliteRenderer writes a database with an addresses table
+type sqliteRenderer struct{}
+
+func (r *sqliteRenderer) Extension() string {
+	return "db"
+}
+
+func (r *sqliteRenderer) Render(w io.Writer, group *subnetGroup) error {
+	return r.RenderCombined(w, []*subnetGroup{group})
+}
+
+// All subnets in one table
+// The database is built in a temporary file, which is then copied to w
+func (r *sqliteRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	tmp, err := ioutil.TempFile("", "gcp-ips-*.db")
+	if err != nil {
+		return err
+	}
+	path := tmp.Name()
+	tmp.Close()
+	defer os.Remove(path)
+
+	if err := writeSQLite(path, groups); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Create the addresses table in the database at path, and insert the addresses of groups
+func writeSQLite(path string, groups []*subnetGroup) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO addresses (subnet, ip, project, status, user, location) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer insert.Close()
+	for _, group := range groups {
+		for _, a := range group.Rows {
+			if _, err := insert.Exec(a.Subnet, a.IP, a.Project, a.Status, a.User, a.Location); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return db.Close()
+}
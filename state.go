@@ -0,0 +1,111 @@
+// Checkpointing of fetched projects so that an interrupted run can be resumed
+//
+// The state file holds one JSON encoded projectResources per line, appended
+// as each project finishes. A run interrupted mid-write leaves at most one
+// truncated line at the end, which is ignored on resume.
+//
+// A -changed-since run doesn't append: it replaces the file with the projects
+// it took from it and the ones it fetched again, so that the file doesn't
+// grow with every run.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stateWriter appends completed projects to the state file
+type stateWriter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// Open the state file for writing
+// When resuming, new projects are appended to the existing file, otherwise it is truncated
+func openState(path string, resume bool) (*stateWriter, error) {
+	flags := os.O_CREATE | os.O_WRONLY
+	if resume {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stateWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record a project as completed
+func (s *stateWriter) record(p *projectResources) error {
+	return s.enc.Encode(p)
+}
+
+func (s *stateWriter) Close() error {
+	return s.f.Close()
+}
+
+// Replace the state file with the complete projects of resources
+// They are written to a temporary file that is then renamed over the state file,
+// so that the previous state is left as it was when writing fails
+func replaceState(path string, resources []*projectResources) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	state := &stateWriter{f: f, enc: json.NewEncoder(f)}
+	for _, p := range resources {
+		if p.Complete() {
+			if err = state.record(p); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		// temporary files are only readable by their owner
+		err = f.Chmod(0644)
+	}
+	if closeErr := state.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load the projects completed by a previous run, keyed by project ID
+// A missing state file is not an error, there is just nothing to resume
+func loadState(path string) (map[string]*projectResources, error) {
+	completed := make(map[string]*projectResources)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return completed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Aggregated lists of large projects make for long lines
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+	for scanner.Scan() {
+		p := &projectResources{}
+		if err := json.Unmarshal(scanner.Bytes(), p); err != nil {
+			logger.Warn("Ignoring unreadable entry in state file", "file", path, "error", err)
+			continue
+		}
+		completed[p.Project] = p
+	}
+
+	return completed, scanner.Err()
+}
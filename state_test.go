@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceStateDoesNotGrow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := openState(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, project := range []string{"svc-1", "svc-2"} {
+		if err := state.record(&projectResources{Project: project}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each incremental run replaces the state with the same projects, and drops incomplete ones
+	for run := 0; run < 3; run++ {
+		resources := []*projectResources{
+			{Project: "svc-1"},
+			{Project: "svc-2"},
+			{Project: "svc-3", Errors: []string{"forbidden"}},
+		}
+		if err := replaceState(path, resources); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("state file has %d lines, want 2", lines)
+	}
+	completed, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := completed["svc-3"]; ok || len(completed) != 2 {
+		t.Errorf("state has %d projects, want svc-1 and svc-2", len(completed))
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
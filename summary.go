@@ -0,0 +1,36 @@
+// Summary of a run, logged before the output is written
+//
+// The counts give immediate feedback on whether a run looks reasonable, e.g.
+// a sudden drop in the number of IPs after a permission change. They are
+// logged, so they go to stderr and stay out of any output on stdout.
+
+package main
+
+// Log the number of IPs by status, of subnets and of projects scanned
+// IPs of instances and forwarding rules that aren't reserved have no status, and are counted apart
+func logSummary(addressesBySubnet map[string][]*AddressInfo, projects int) {
+	var total, reserved, inUse, unreserved, other int
+	// counted by the subnet of each IP, as the addresses may be grouped by something else
+	subnets := make(map[string]bool)
+	for _, addressInfoList := range addressesBySubnet {
+		for _, addressInfo := range addressInfoList {
+			total++
+			if addressInfo.Subnet != "" {
+				subnets[addressInfo.Subnet] = true
+			}
+			switch addressInfo.Status {
+			case "RESERVED":
+				reserved++
+			case "IN_USE":
+				inUse++
+			case "":
+				unreserved++
+			default:
+				other++
+			}
+		}
+	}
+
+	logger.Info("Summary", "ips", total, "subnets", len(subnets), "projects", projects,
+		"reserved", reserved, "in_use", inUse, "unreserved", unreserved, "other_status", other)
+}
@@ -0,0 +1,83 @@
+// Sending the inventory to an HTTP endpoint, e.g. a central CMDB
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Number of attempts at posting the results, retrying on server errors
+const postAttempts = 3
+
+// Flatten the addresses of all subnets into a single list, sorted by subnet and IP
+func allAddresses(addressesBySubnet map[string][]*AddressInfo, opts *options) []*AddressInfo {
+	var all []*AddressInfo
+	for _, subnet := range subnetNames(addressesBySubnet, opts) {
+		addressInfoList := addressesBySubnet[subnet]
+		sortByIP(addressInfoList)
+		all = append(all, addressInfoList...)
+	}
+	return all
+}
+
+// POST all addresses as a JSON array to url, with their IPs masked like the files
+// Authenticates with a bearer token or "user:password" basic auth when given
+// Server errors (5xx) and connection failures are retried with a growing delay, until ctx is done
+func postResults(ctx context.Context, url string, addressesBySubnet map[string][]*AddressInfo, opts *options) error {
+	body, err := json.Marshal(maskRows(allAddresses(addressesBySubnet, opts), opts.maskIP))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	delay := time.Second
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		// -post-token and -post-basic-auth are exclusive
+		if opts.PostToken != "" {
+			req.Header.Set("Authorization", "Bearer "+opts.PostToken)
+		} else if opts.PostBasicAuth != "" {
+			user, password := opts.PostBasicAuth, ""
+			if i := strings.Index(opts.PostBasicAuth, ":"); i >= 0 {
+				user, password = opts.PostBasicAuth[:i], opts.PostBasicAuth[i+1:]
+			}
+			req.SetBasicAuth(user, password)
+		}
+
+		resp, err := client.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				logger.Progress("Posted results", "bytes", len(body), "url", url)
+				return nil
+			}
+			err = fmt.Errorf("%s returned %s", url, resp.Status)
+			if resp.StatusCode < 500 {
+				return err
+			}
+		}
+
+		if attempt == postAttempts {
+			return err
+		}
+		logger.Warn("Error posting results, retrying", "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,104 @@
+// Excel workbook output, with a sheet per subnet
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Longest sheet name Excel accepts
+const maxSheetName = 31
+
+// Replaces the characters Excel doesn't accept in sheet names
+var sheetNameReplacer = strings.NewReplacer(":", "_", "\\", "_", "/", "_", "?", "_", "*", "_", "[", "_", "]", "_")
+
+// xlsxRenderer writes a workbook with a sheet per subnet, each with a bold, frozen header row
+type xlsxRenderer struct {
+	columns []column
+}
+
+func (r *xlsxRenderer) Extension() string {
+	return "xlsx"
+}
+
+func (r *xlsxRenderer) Render(w io.Writer, group *subnetGroup) error {
+	return r.RenderCombined(w, []*subnetGroup{group})
+}
+
+// All subnets in one workbook
+func (r *xlsxRenderer) RenderCombined(w io.Writer, groups []*subnetGroup) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+
+	// new workbooks come with an empty sheet, which is removed once there is another one
+	defaultSheet := f.GetSheetName(0)
+	used := make(map[string]bool)
+	for _, group := range groups {
+		sheet := sheetName(group.Name, used)
+		if _, err := f.NewSheet(sheet); err != nil {
+			return err
+		}
+		if err := r.writeSheet(f, sheet, group, bold); err != nil {
+			return fmt.Errorf("sheet %s: %s", sheet, err)
+		}
+	}
+	if len(groups) > 0 && !used[strings.ToLower(defaultSheet)] {
+		if err := f.DeleteSheet(defaultSheet); err != nil {
+			return err
+		}
+	}
+
+	return f.Write(w)
+}
+
+// Write the header and rows of group to sheet
+func (r *xlsxRenderer) writeSheet(f *excelize.File, sheet string, group *subnetGroup, headerStyle int) error {
+	rows := append([][]string{tableHeader(r.columns)}, tableData(r.columns, group.Rows)...)
+	for i, row := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			return err
+		}
+	}
+	if err := f.SetRowStyle(sheet, 1, 1, headerStyle); err != nil {
+		return err
+	}
+	return f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+}
+
+// Name of the sheet of a subnet: the subnet name, without the characters Excel
+// doesn't accept and shortened to its limit, made unique among the used names
+func sheetName(subnet string, used map[string]bool) string {
+	base := []rune(sheetNameReplacer.Replace(subnet))
+	if len(base) > maxSheetName {
+		base = base[:maxSheetName]
+	}
+	name := string(base)
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		suffix := fmt.Sprintf("~%d", i)
+		trimmed := base
+		if len(trimmed)+len(suffix) > maxSheetName {
+			trimmed = trimmed[:maxSheetName-len(suffix)]
+		}
+		name = string(trimmed) + suffix
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}